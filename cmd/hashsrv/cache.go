@@ -0,0 +1,61 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+// programCache is a least-recently-used cache of compiled programs keyed by URL path
+type programCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	prog *engine.Program
+}
+
+func newProgramCache(max int) *programCache {
+	return &programCache{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached program for the path, if any
+func (c *programCache) get(key string) (*engine.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).prog, true
+}
+
+// add caches the program for the path, evicting the oldest entry when full
+func (c *programCache) add(key string, p *engine.Program) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*cacheEntry).prog = p
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, prog: p})
+	if c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheEntry).key)
+	}
+}
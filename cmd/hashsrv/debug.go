@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// debugTokenHeader carries the token that allows debug mode. It does not use
+// the Hashsrv- prefix, so it never becomes a variable.
+const debugTokenHeader = "X-Hashsrv-Debug-Token"
+
+// debugAllowed lists the client addresses allowed to use debug mode
+var debugAllowed []netip.Prefix
+
+// secretPatterns match the names of header variables that are redacted
+var secretPatterns []string
+
+// parseDebugAllow parses a comma-separated list of addresses and CIDR ranges
+func parseDebugAllow(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// parseSecretPatterns parses a comma-separated list of variable name patterns
+func parseSecretPatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if _, err := path.Match(item, ""); err != nil {
+			return nil, fmt.Errorf("secret pattern %q: %w", item, err)
+		}
+		patterns = append(patterns, item)
+	}
+	return patterns, nil
+}
+
+// isSecretName reports whether a header variable should be redacted
+func isSecretName(name string) bool {
+	for _, p := range secretPatterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// canDebug reports whether the request may use debug mode. When neither
+// addresses nor a token are configured, no client may.
+func canDebug(r *http.Request) bool {
+	if debugToken != "" {
+		t := r.Header.Get(debugTokenHeader)
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(debugToken)) == 1 {
+			return true
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range debugAllowed {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+const problemType = "application/problem+json"
+
+// statusClientClosed is the nonstandard status logged when the client goes
+// away before the run finishes. The client never sees it.
+const statusClientClosed = 499
+
+// problem is an RFC 9457 problem details body
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	Code       string `json:"code,omitempty"`
+	Op         string `json:"op,omitempty"`
+	Index      *int   `json:"index,omitempty"`
+	StackDepth *int   `json:"stackDepth,omitempty"`
+}
+
+// errorStatus returns the HTTP status and a short code for an error from the engine
+func errorStatus(err error) (int, string) {
+	var (
+		underflow *engine.StackUnderflowError
+		unused    *engine.UnusedValuesError
+		decode    *engine.DecodeError
+		invalid   *engine.InvalidArgumentError
+		verify    *engine.VerificationFailedError
+		limit     *engine.LimitExceededError
+		readOnly  *engine.ReadOnlyError
+	)
+	switch {
+	case errors.Is(err, engine.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, "timeout"
+	case errors.Is(err, context.Canceled):
+		return statusClientClosed, "canceled"
+	case errors.Is(err, engine.ErrValueBytes), errors.Is(err, engine.ErrTotalBytes), errors.Is(err, engine.ErrSpillBytes):
+		return http.StatusRequestEntityTooLarge, "limit-exceeded"
+	case errors.As(err, &limit):
+		return http.StatusUnprocessableEntity, "limit-exceeded"
+	case errors.As(err, &verify):
+		return http.StatusUnprocessableEntity, "verification-failed"
+	case errors.Is(err, engine.ErrDefaultKey):
+		return http.StatusForbidden, "default-key"
+	case errors.As(err, &readOnly):
+		return http.StatusBadRequest, "read-only"
+	case errors.As(err, &underflow):
+		return http.StatusBadRequest, "stack-underflow"
+	case errors.As(err, &unused):
+		return http.StatusBadRequest, "unused-values"
+	case errors.As(err, &decode):
+		return http.StatusBadRequest, "decode"
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest, "invalid-argument"
+	}
+	return http.StatusInternalServerError, ""
+}
+
+// writeError responds with the error, as problem details if the client accepts them
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	if negotiate(r, "text/plain", problemType) != problemType {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	title := http.StatusText(status)
+	if status == statusClientClosed {
+		title = "Client Closed Request"
+	}
+	p := problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: status,
+		Detail: err.Error(),
+		Code:   code,
+	}
+	var oe *engine.OpError
+	if errors.As(err, &oe) {
+		p.Op, p.Index, p.StackDepth = oe.Op, &oe.Index, &oe.StackDepth
+	}
+	b, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", problemType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(b)
+}
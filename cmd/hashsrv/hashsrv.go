@@ -1,16 +1,19 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/ancientlore/flagcfg"
+	"github.com/ancientlore/hashsrv/engine"
 	"github.com/facebookgo/flagenv"
 	"github.com/kardianos/service"
 )
@@ -22,6 +25,27 @@ var wsLogger service.Logger
 var svcRun bool
 var cfgFile string
 var hostAddr string
+var cacheSize int
+var programs *programCache
+var limits engine.Limits
+var headerVars string
+var allowedHeaders map[string]bool
+var serverKey string
+var keyFile string
+var secretDir string
+var strictKey bool
+var secrets map[string][]byte
+var keyringDir string
+var keyringSpec string
+var keyring *engine.Keyring
+var debugAllow string
+var debugToken string
+var secretVars string
+var logLevel string
+var logFormat string
+var stream bool
+var spillBytes int
+var tempDir string
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of hashsrv:\n")
@@ -30,7 +54,8 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "The location of the config file can be set with\nthe \"HASHSRV_CONFIG\" environment variable; otherwise\na standard set of locations is searched.\n")
 }
 
-func init() {
+// configure reads the options and sets up the service
+func configure() {
 	const (
 		name        = "HashSrv"
 		displayName = "HashSrv"
@@ -45,7 +70,9 @@ func init() {
 	var svcStart bool
 	var svcStop bool
 
-	flag.BoolVar(&noisy, "noisy", false, "Enable logging")
+	flag.BoolVar(&noisy, "noisy", false, "Enable debug logging, the same as -loglevel debug")
+	flag.StringVar(&logLevel, "loglevel", "info", "Level of messages to log: debug, info, warn, or error")
+	flag.StringVar(&logFormat, "logformat", "text", "Format of the log: text or json")
 	flag.BoolVar(&help, "help", false, "Show command help")
 	flag.BoolVar(&ver, "version", false, "Show version")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "Write CPU profile to file")
@@ -56,6 +83,27 @@ func init() {
 	flag.BoolVar(&svcStart, "start", false, "Start the HashSrv service")
 	flag.BoolVar(&svcStop, "stop", false, "Stop the HashSrv service")
 	flag.StringVar(&hostAddr, "addr", ":9009", "Address to host the service on")
+	flag.IntVar(&cacheSize, "cache", 1000, "Number of compiled pipelines to cache")
+	flag.IntVar(&limits.MaxCallDepth, "maxdepth", 64, "Maximum depth of nested calls (0 for no limit)")
+	flag.IntVar(&limits.MaxStackItems, "maxstack", 1000, "Maximum number of values on the stack (0 for no limit)")
+	flag.IntVar(&limits.MaxValueBytes, "maxvalue", 64<<20, "Maximum size of a single value held in memory in bytes (0 for no limit)")
+	flag.IntVar(&limits.MaxTotalBytes, "maxtotal", 256<<20, "Maximum combined size of the values on the stack and in variables held in memory in bytes (0 for no limit)")
+	flag.IntVar(&limits.MaxSpillBytes, "maxspill", 1<<30, "Maximum combined size of the temporary files for a request in bytes (0 for no limit)")
+	flag.IntVar(&spillBytes, "spill", 8<<20, "Size in bytes above which request bodies and values made from them are kept in temporary files (0 to keep them in memory)")
+	flag.StringVar(&tempDir, "tempdir", "", "Folder for temporary files (empty for the system default)")
+	flag.IntVar(&limits.MaxOps, "maxops", 100000, "Maximum number of operations per request (0 for no limit)")
+	flag.StringVar(&headerVars, "headervars", "", "Comma-separated list of variables that may be set using Hashsrv- headers (empty for any)")
+	flag.StringVar(&serverKey, "key", "", "Key to use instead of the default key")
+	flag.StringVar(&keyFile, "keyfile", "", "File containing the key to use instead of the default key")
+	flag.StringVar(&secretDir, "secrets", "", "Folder of files containing named secrets, where each file name is a variable name")
+	flag.BoolVar(&strictKey, "strictkey", false, "Refuse to use the default key")
+	flag.StringVar(&keyringDir, "keyring", "", "Folder of keys for protect and unprotect, named <id>.<state> and containing hex key material")
+	flag.StringVar(&keyringSpec, "keys", "", "Comma-separated keys for protect and unprotect, formatted as id:state:hex")
+	flag.StringVar(&debugAllow, "debugallow", "", "Comma-separated addresses and CIDR ranges allowed to use debug mode")
+	flag.StringVar(&debugToken, "debugtoken", "", "Token that allows debug mode when sent in the X-Hashsrv-Debug-Token header")
+	flag.StringVar(&secretVars, "secretvars", "*key*,*secret*,*token*,*password*", "Comma-separated patterns of header variables to redact in debug mode")
+	flag.BoolVar(&stream, "stream", true, "Stream request bodies through pipelines that support it instead of reading them into memory")
+	flag.DurationVar(&limits.Timeout, "timeout", 30*time.Second, "Maximum time to process a request (0 for no limit)")
 	flag.Parse()
 	flagcfg.AddDefaults()
 	flagcfg.Parse()
@@ -72,51 +120,78 @@ func init() {
 		os.Exit(0)
 	}
 
-	var err error
+	if noisy {
+		logLevel = "debug"
+	}
+	err := setupLogging(logLevel, logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	var i impl
 	wsHashSrv, err = service.New(i, &service.Config{Name: name, DisplayName: displayName, Description: desc})
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	wsLogger, err = wsHashSrv.Logger(nil)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if svcInstall && svcRemove {
-		log.Fatalln("Options -install and -remove cannot be used together.")
+		fatal(errors.New("options -install and -remove cannot be used together"))
 	} else if svcInstall {
 		err = wsHashSrv.Install()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		log.Printf("Service \"%s\" installed.\n", displayName)
+		slog.Info("service installed", "name", displayName)
 		os.Exit(0)
 	} else if svcRemove {
 		err = wsHashSrv.Uninstall()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		log.Printf("Service \"%s\" removed.\n", displayName)
+		slog.Info("service removed", "name", displayName)
 		os.Exit(0)
 	} else if svcStart {
 		err = wsHashSrv.Start()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		log.Printf("Service \"%s\" started.\n", displayName)
+		slog.Info("service started", "name", displayName)
 		os.Exit(0)
 	} else if svcStop {
 		err = wsHashSrv.Stop()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		log.Printf("Service \"%s\" stopped.\n", displayName)
+		slog.Info("service stopped", "name", displayName)
 		os.Exit(0)
 	}
 
-	if !noisy {
-		log.SetOutput(ioutil.Discard)
+	if headerVars != "" {
+		allowedHeaders = make(map[string]bool)
+		for _, name := range strings.Split(headerVars, ",") {
+			allowedHeaders[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+	secrets, err = loadSecrets()
+	if err != nil {
+		fatal(err)
+	}
+	keyring, err = loadKeyring()
+	if err != nil {
+		fatal(err)
+	}
+	debugAllowed, err = parseDebugAllow(debugAllow)
+	if err != nil {
+		fatal(err)
+	}
+	secretPatterns, err = parseSecretPatterns(secretVars)
+	if err != nil {
+		fatal(err)
 	}
 }
 
@@ -126,7 +201,7 @@ func (i impl) Start(s service.Service) error {
 	// start
 	go startWork()
 	wsLogger.Info(fmt.Sprintf("Started HashSrv using config file \"%s\"", cfgFile))
-	log.Printf("Started HashSrv using config file \"%s\"\n", cfgFile)
+	slog.Info("started HashSrv", "config", cfgFile)
 	return nil
 
 }
@@ -135,17 +210,19 @@ func (i impl) Stop(s service.Service) error {
 	// stop
 	stopWork()
 	wsLogger.Info("Stopped HashSrv")
-	log.Println("Stopped HashSrv")
+	slog.Info("stopped HashSrv")
 	return nil
 }
 
 func main() {
 	var err error
 
+	configure()
+
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		defer f.Close()
 		pprof.StartCPUProfile(f)
@@ -158,19 +235,20 @@ func main() {
 		sigChan := make(chan os.Signal, 2)
 		signal.Notify(sigChan, os.Interrupt)
 		event := <-sigChan
-		log.Print(event)
+		slog.Info("stopping", "signal", event)
 		return
 	} else {
 		err = wsHashSrv.Run()
 		if err != nil {
 			wsLogger.Error(err.Error())
-			log.Println(err)
+			slog.Error(err.Error())
 		}
 	}
 }
 
 func startWork() {
-	http.HandleFunc("/", root)
+	programs = newProgramCache(cacheSize)
+	http.Handle("/", withLogging(http.HandlerFunc(root)))
 	go http.ListenAndServe(hostAddr, nil)
 }
 
@@ -179,7 +257,7 @@ func stopWork() {
 	if memprofile != "" {
 		f, err := os.Create(memprofile)
 		if err != nil {
-			log.Print(err)
+			slog.Error("cannot write memory profile", "error", err)
 		} else {
 			pprof.WriteHeapProfile(f)
 			f.Close()
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	programs = newProgramCache(100)
+	os.Exit(m.Run())
+}
+
+// serve runs a request through the server's handler
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	withLogging(http.HandlerFunc(root)).ServeHTTP(w, r)
+	return w
+}
+
+func TestCanDebug(t *testing.T) {
+	defer func() { debugAllowed, debugToken = nil, "" }()
+
+	for _, tc := range []struct {
+		allow, token string
+		remote       string
+		header       string
+		ok           bool
+	}{
+		{remote: "127.0.0.1:1234", ok: false},
+		{remote: "192.0.2.1:1234", ok: false},
+		{allow: "192.0.2.0/24", remote: "192.0.2.1:1234", ok: true},
+		{allow: "192.0.2.0/24", remote: "198.51.100.1:1234", ok: false},
+		{allow: "::1", remote: "[::1]:1234", ok: true},
+		{allow: "127.0.0.1", remote: "[::ffff:127.0.0.1]:1234", ok: true},
+		{token: "t0ken", remote: "192.0.2.1:1234", header: "t0ken", ok: true},
+		{token: "t0ken", remote: "192.0.2.1:1234", header: "wrong", ok: false},
+		{token: "t0ken", remote: "192.0.2.1:1234", ok: false},
+	} {
+		var err error
+		debugAllowed, err = parseDebugAllow(tc.allow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		debugToken = tc.token
+		r := httptest.NewRequest("GET", "/Hello/md5?debug=1", nil)
+		r.RemoteAddr = tc.remote
+		if tc.header != "" {
+			r.Header.Set(debugTokenHeader, tc.header)
+		}
+		if ok := canDebug(r); ok != tc.ok {
+			t.Errorf("allow %q, token %q, remote %s: expected %v, got %v", tc.allow, tc.token, tc.remote, tc.ok, ok)
+		}
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	defer func() { debugAllowed = nil }()
+
+	r := httptest.NewRequest("GET", "/Hello/md5/hex?debug=1", nil)
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Errorf("expected debug mode to be refused by default, got %d", w.Code)
+	}
+
+	debugAllowed, _ = parseDebugAllow("192.0.2.0/24")
+	r = httptest.NewRequest("GET", "/Hello/md5/hex?debug=1", nil)
+	w := serve(r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<html>") {
+		t.Errorf("expected the debug page, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{htmlType, jsonType, openAPIType}
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{"", htmlType},
+		{"*/*", htmlType},
+		{"application/json", jsonType},
+		{"application/*", jsonType},
+		{"application/vnd.oai.openapi+json, application/json;q=0.5", openAPIType},
+		{"text/*;q=0.5, application/json;q=0.9", jsonType},
+		{"text/html;q=0, */*", jsonType},
+		{"text/html;q=0, application/json;q=0, */*;q=0", ""},
+		{"image/png", ""},
+		{"application/json;q=bad", ""},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		if got := negotiate(r, offers...); got != tc.want {
+			t.Errorf("Accept %q: expected %q, got %q", tc.accept, tc.want, got)
+		}
+	}
+}
+
+func TestHelpHandler(t *testing.T) {
+	secrets = map[string][]byte{"signing-key": []byte("s3cret")}
+	defer func() { secrets = nil }()
+
+	for _, tc := range []struct {
+		path, accept string
+		status       int
+		contentType  string
+		contains     string
+	}{
+		{"/", "", http.StatusOK, "text/html", "<h1>hashsrv</h1>"},
+		{"/help", "application/json", http.StatusOK, jsonType, `"name": "md5"`},
+		{"/", "application/vnd.oai.openapi+json", http.StatusOK, jsonType, `"openapi": "3.0.3"`},
+		{"/openapi.json", "", http.StatusOK, jsonType, `"x-hashsrv-functions"`},
+		{"/help/md5", "", http.StatusOK, "text/html", "Hashes data using MD5"},
+		{"/help/md5", "application/json", http.StatusOK, jsonType, `"category": "Hash"`},
+		{"/help/nope", "", http.StatusNotFound, "text/plain", "no function called nope"},
+		{"/help", "image/png", http.StatusNotAcceptable, "text/plain", "only available as"},
+		{"/help/md5", "text/html;q=0, application/json;q=0", http.StatusNotAcceptable, "text/plain", "only available as"},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		w := serve(r)
+		body := w.Body.String()
+		if w.Code != tc.status || !strings.HasPrefix(w.Header().Get("Content-Type"), tc.contentType) || !strings.Contains(body, tc.contains) {
+			t.Errorf("%s with Accept %q: unexpected %d %s response %.200s", tc.path, tc.accept, w.Code, w.Header().Get("Content-Type"), body)
+		}
+		if strings.Contains(body, "s3cret") {
+			t.Errorf("%s shows a secret", tc.path)
+		}
+	}
+}
+
+func TestHeaderVars(t *testing.T) {
+	secrets = map[string][]byte{"key": []byte("server key")}
+	defer func() { secrets, allowedHeaders = nil, nil }()
+
+	for _, tc := range []struct {
+		allowed string
+		header  string
+		status  int
+	}{
+		{"", "Hashsrv-Greeting", http.StatusOK},
+		{"", "Hashsrv-Body", http.StatusBadRequest},
+		{"", "Hashsrv-Key", http.StatusBadRequest},
+		{"", "Hashsrv-Encrypt-Aes", http.StatusBadRequest},
+		{"greeting", "Hashsrv-Greeting", http.StatusOK},
+		{"other", "Hashsrv-Greeting", http.StatusBadRequest},
+	} {
+		allowedHeaders = nil
+		if tc.allowed != "" {
+			allowedHeaders = map[string]bool{tc.allowed: true}
+		}
+		r := httptest.NewRequest("GET", "/greeting/load", nil)
+		r.Header.Set(tc.header, "Hello")
+		w := serve(r)
+		if w.Code != tc.status {
+			t.Errorf("allowed %q, header %s: expected %d, got %d %s", tc.allowed, tc.header, tc.status, w.Code, w.Body)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	limit := func(err error) error {
+		return &engine.OpError{Op: "x", Err: &engine.LimitExceededError{Err: err, Limit: 1}}
+	}
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{limit(engine.ErrValueBytes), http.StatusRequestEntityTooLarge, "limit-exceeded"},
+		{limit(engine.ErrTotalBytes), http.StatusRequestEntityTooLarge, "limit-exceeded"},
+		{limit(engine.ErrSpillBytes), http.StatusRequestEntityTooLarge, "limit-exceeded"},
+		{limit(engine.ErrOps), http.StatusUnprocessableEntity, "limit-exceeded"},
+		{limit(engine.ErrCallDepth), http.StatusUnprocessableEntity, "limit-exceeded"},
+		{limit(engine.ErrStackItems), http.StatusUnprocessableEntity, "limit-exceeded"},
+		{limit(engine.ErrTimeout), http.StatusServiceUnavailable, "timeout"},
+		{fmt.Errorf("x: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "timeout"},
+		{fmt.Errorf("x: %w", context.Canceled), statusClientClosed, "canceled"},
+		{&engine.VerificationFailedError{}, http.StatusUnprocessableEntity, "verification-failed"},
+		{engine.ErrDefaultKey, http.StatusForbidden, "default-key"},
+		{&engine.StackUnderflowError{}, http.StatusBadRequest, "stack-underflow"},
+		{errors.New("oops"), http.StatusInternalServerError, ""},
+	} {
+		status, code := errorStatus(tc.err)
+		if status != tc.status || code != tc.code {
+			t.Errorf("%v: expected %d %q, got %d %q", tc.err, tc.status, tc.code, status, code)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	defer func() { limits = engine.Limits{} }()
+
+	for _, tc := range []struct {
+		path   string
+		limits engine.Limits
+		status int
+		code   string
+		op     string
+	}{
+		{"/Hello/md5/'x", engine.Limits{}, http.StatusBadRequest, "unused-values", ""},
+		{"/Hello/md5/hex/hex/hex", engine.Limits{MaxOps: 3}, http.StatusUnprocessableEntity, "limit-exceeded", "hex"},
+		{"/Hello/push/append/push/append", engine.Limits{MaxValueBytes: 16}, http.StatusRequestEntityTooLarge, "limit-exceeded", "append"},
+		{"/Hello/md5/'x/eq/'same", engine.Limits{}, http.StatusUnprocessableEntity, "verification-failed", "eq"},
+	} {
+		limits = tc.limits
+		r := httptest.NewRequest("GET", tc.path, nil)
+		w := serve(r)
+		if w.Code != tc.status || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("%s: expected a plain %d, got %d %s", tc.path, tc.status, w.Code, w.Header().Get("Content-Type"))
+		}
+
+		r = httptest.NewRequest("GET", tc.path, nil)
+		r.Header.Set("Accept", problemType)
+		w = serve(r)
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Errorf("%s: %v in %s", tc.path, err, w.Body)
+			continue
+		}
+		if w.Code != tc.status || w.Header().Get("Content-Type") != problemType ||
+			p.Status != tc.status || p.Title != http.StatusText(tc.status) || p.Code != tc.code || p.Op != tc.op || p.Detail == "" {
+			t.Errorf("%s: unexpected %d response %s", tc.path, w.Code, w.Body)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/Hello/md5/hex", nil).WithContext(ctx)
+	r.Header.Set("Accept", problemType)
+	w := serve(r)
+	if w.Code != statusClientClosed || !strings.Contains(w.Body.String(), `"title":"Client Closed Request"`) {
+		t.Errorf("cancelled request: unexpected %d response %s", w.Code, w.Body)
+	}
+}
+
+func TestStreamBody(t *testing.T) {
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer func() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		stream = false
+	}()
+
+	for _, path := range []string{
+		"/sha256/hex",
+		"/MyKey/hmac-sha256/base64",
+		"/depth/'1/eq/sha256/hex",
+		"/hex:626f6479/load/pop/sha256/hex",
+	} {
+		var results [2]string
+		for i, s := range []bool{false, true} {
+			stream = s
+			logs.Reset()
+			w := serve(httptest.NewRequest("POST", path, strings.NewReader("Hello")))
+			if w.Code != http.StatusOK {
+				t.Errorf("%s with streaming %v: unexpected %d %s", path, s, w.Code, w.Body)
+			}
+			results[i] = w.Body.String()
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s: streamed result %q differs from %q", path, results[1], results[0])
+		}
+	}
+
+	// an error after part of the result is sent aborts the response, but
+	// the request is still logged
+	stream = true
+	logs.Reset()
+	body := strings.Repeat("00", 100000) + "zz"
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("expected the response to be aborted, got %v", v)
+			}
+		}()
+		serve(httptest.NewRequest("POST", "/unhex", strings.NewReader(body)))
+	}()
+	if !strings.Contains(logs.String(), "stream=true") || !strings.Contains(logs.String(), "msg=request") {
+		t.Errorf("expected the failure and the request to be logged, got %s", logs.String())
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, tc := range []struct {
+		path, logged string
+	}{
+		{"/Hello/hex:00112233/hmac-sha256/hex", "pipeline=/*/*/hmac-sha256/hex"},
+		{"/'s3cret/sha256", "pipeline=/*/sha256"},
+		{"/help/sha256", "pipeline=/help/sha256"},
+		{"/hex:zz", "pipeline=(invalid)"},
+	} {
+		logs.Reset()
+		serve(httptest.NewRequest("GET", tc.path, nil))
+		if !strings.Contains(logs.String(), tc.logged) || strings.Contains(logs.String(), "s3cret") || strings.Contains(logs.String(), "00112233") {
+			t.Errorf("%s: expected %s in the access log, got %s", tc.path, tc.logged, logs.String())
+		}
+	}
+}
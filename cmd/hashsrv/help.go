@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+const (
+	htmlType    = "text/html"
+	jsonType    = "application/json"
+	openAPIType = "application/vnd.oai.openapi+json"
+)
+
+// isHelpPath reports whether a GET for the escaped path is served by serveHelp
+func isHelpPath(p string) bool {
+	return p == "" || p == "help" || strings.HasPrefix(p, "help/") || p == "openapi.json"
+}
+
+// serveHelp serves the help pages, the JSON function catalog, and the
+// OpenAPI document, using the Accept header to pick the format
+func serveHelp(w http.ResponseWriter, r *http.Request, eng *engine.Engine, p string) {
+	w.Header().Set("Vary", "Accept")
+	var v any
+	switch {
+	case p == "openapi.json":
+		v = openAPI(eng)
+	case strings.HasPrefix(p, "help/"):
+		name, err := url.PathUnescape(strings.TrimPrefix(p, "help/"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "", err)
+			return
+		}
+		doc, ok := eng.Describe(name)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "unknown-function", errors.New("no function called "+name))
+			return
+		}
+		switch negotiate(r, htmlType, jsonType) {
+		case htmlType:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(eng.HelpFunc(name))
+			return
+		case jsonType:
+			v = doc
+		default:
+			notAcceptable(w, r, htmlType, jsonType)
+			return
+		}
+	default:
+		switch negotiate(r, htmlType, jsonType, openAPIType) {
+		case htmlType:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(eng.Help())
+			return
+		case jsonType:
+			v = eng.Catalog()
+		case openAPIType:
+			v = openAPI(eng)
+		default:
+			notAcceptable(w, r, htmlType, jsonType, openAPIType)
+			return
+		}
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(b)
+}
+
+// notAcceptable responds that none of the offered media types is acceptable
+func notAcceptable(w http.ResponseWriter, r *http.Request, offers ...string) {
+	writeError(w, r, http.StatusNotAcceptable, "not-acceptable", errors.New("the response is only available as "+strings.Join(offers, ", ")))
+}
+
+// negotiate returns the offered media type that the Accept header prefers,
+// or an empty string if none is acceptable. Without an Accept header, it
+// returns the first offer. Each offer takes the quality of the most specific
+// media range that matches it.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+	quality := make([]float64, len(offers))
+	specific := make([]int, len(offers))
+	for i := range specific {
+		specific[i] = -1
+	}
+	for _, item := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		for i, offer := range offers {
+			spec := -1
+			switch {
+			case mt == offer:
+				spec = 2
+			case strings.HasSuffix(mt, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mt, "*")):
+				spec = 1
+			case mt == "*/*":
+				spec = 0
+			}
+			if spec > specific[i] {
+				quality[i], specific[i] = q, spec
+			}
+		}
+	}
+	best := -1
+	for i := range offers {
+		if quality[i] > 0 && (best < 0 || quality[i] > quality[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return offers[best]
+}
+
+// openAPI describes the server's endpoints and every function
+func openAPI(eng *engine.Engine) map[string]any {
+	catalog := eng.Catalog()
+	names := make([]string, len(catalog))
+	descs := make([]string, len(catalog))
+	for i, doc := range catalog {
+		names[i] = doc.Name
+		descs[i] = doc.Desc
+	}
+
+	text := map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}
+	binary := map[string]any{"application/octet-stream": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	failure := map[string]any{
+		"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+		problemType:  map[string]any{"schema": ref("Problem")},
+	}
+	errorResponses := map[string]any{
+		"400": map[string]any{"description": "The pipeline, a header, or a value on the stack is invalid", "content": failure},
+		"403": map[string]any{"description": "Debug mode or the default key is not allowed", "content": failure},
+		"413": map[string]any{"description": "The run exceeded a size limit", "content": failure},
+		"422": map[string]any{"description": "A verification in the pipeline failed, or the run exceeded the operation, call depth, or stack limit", "content": failure},
+		"500": map[string]any{"description": "The pipeline failed", "content": failure},
+		"503": map[string]any{"description": "The run exceeded the time limit", "content": failure},
+	}
+	pipeline := func(summary string) map[string]any {
+		op := map[string]any{
+			"summary": summary,
+			"parameters": []any{
+				map[string]any{"name": "pipeline", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+					"description": "Commands separated by slashes. Each command is a function from the catalog, a named program followed by call, or a literal to push on the stack."},
+				map[string]any{"name": "debug", "in": "query", "schema": map[string]any{"type": "string", "enum": []string{"1", "json"}},
+					"description": "Returns an HTML or JSON trace of the run instead of the result"},
+				map[string]any{"name": "X-Request-ID", "in": "header", "schema": map[string]any{"type": "string"},
+					"description": "ID to include in the log lines for the request"},
+			},
+			"responses": merge(map[string]any{
+				"200": map[string]any{"description": "The value left on the stack", "content": binary},
+			}, errorResponses),
+		}
+		return op
+	}
+	post := pipeline("Runs a pipeline on the request body")
+	post["requestBody"] = map[string]any{"description": "The initial value on the stack", "content": binary}
+
+	catalogResponse := map[string]any{
+		jsonType: map[string]any{"schema": map[string]any{"type": "array", "items": ref("FuncDoc")}},
+		htmlType: map[string]any{"schema": map[string]any{"type": "string"}},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "hashsrv",
+			"version":     HASHSRV_VERSION,
+			"description": "A web service that performs hashing, encryption, encoding, and compression using pipelines of functions in the URL path.",
+		},
+		"paths": map[string]any{
+			"/{pipeline}": map[string]any{
+				"get":  pipeline("Runs a pipeline with an empty stack"),
+				"post": post,
+			},
+			"/help": map[string]any{
+				"get": map[string]any{
+					"summary": "Lists the functions",
+					"responses": map[string]any{
+						"200": map[string]any{"description": "The function catalog", "content": catalogResponse},
+						"406": map[string]any{"description": "None of the formats is acceptable", "content": text},
+					},
+				},
+			},
+			"/help/{function}": map[string]any{
+				"get": map[string]any{
+					"summary": "Describes a function",
+					"parameters": []any{
+						map[string]any{"name": "function", "in": "path", "required": true, "schema": ref("Function")},
+					},
+					"responses": map[string]any{
+						"200": map[string]any{"description": "The function", "content": map[string]any{
+							jsonType: map[string]any{"schema": ref("FuncDoc")},
+							htmlType: map[string]any{"schema": map[string]any{"type": "string"}},
+						}},
+						"404": map[string]any{"description": "There is no such function", "content": text},
+						"406": map[string]any{"description": "None of the formats is acceptable", "content": text},
+					},
+				},
+			},
+			"/openapi.json": map[string]any{
+				"get": map[string]any{
+					"summary":   "Returns this document",
+					"responses": map[string]any{"200": map[string]any{"description": "The OpenAPI document", "content": map[string]any{jsonType: map[string]any{}}}},
+				},
+			},
+		},
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Function": map[string]any{"type": "string", "enum": names, "x-enum-descriptions": descs},
+				"Example": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"pipeline": map[string]any{"type": "string"},
+						"input":    map[string]any{"type": "string"},
+						"output":   map[string]any{"type": "string"},
+					},
+					"required": []string{"pipeline", "output"},
+				},
+				"Problem": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"type":       map[string]any{"type": "string"},
+						"title":      map[string]any{"type": "string"},
+						"status":     map[string]any{"type": "integer"},
+						"detail":     map[string]any{"type": "string"},
+						"code":       map[string]any{"type": "string", "description": "The kind of error, such as stack-underflow or decode"},
+						"op":         map[string]any{"type": "string", "description": "The command that failed"},
+						"index":      map[string]any{"type": "integer", "description": "The position of the command in the pipeline, starting at 0"},
+						"stackDepth": map[string]any{"type": "integer", "description": "The number of values on the stack when the command started"},
+					},
+				},
+				"FuncDoc": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":        ref("Function"),
+						"category":    map[string]any{"type": "string"},
+						"in":          map[string]any{"type": "string", "description": "The values taken from the stack, separated by commas"},
+						"out":         map[string]any{"type": "string", "description": "The values left on the stack, separated by commas"},
+						"description": map[string]any{"type": "string"},
+						"examples":    map[string]any{"type": "array", "items": ref("Example")},
+					},
+					"required": []string{"name", "category", "in", "out", "description"},
+				},
+			},
+		},
+		"x-hashsrv-functions": catalog,
+	}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func merge(a, b map[string]any) map[string]any {
+	for k, v := range b {
+		a[k] = v
+	}
+	return a
+}
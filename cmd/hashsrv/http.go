@@ -1,9 +1,13 @@
 package main
 
 import (
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/ancientlore/hashsrv/engine"
 )
@@ -13,56 +17,139 @@ const (
 	prefixLen = len(prefix)
 )
 
+// engines holds engines that can be reused by later requests
+var engines = sync.Pool{
+	New: func() any { return engine.New() },
+}
+
 func root(w http.ResponseWriter, r *http.Request) {
-	// read body
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	// Initialize engine
+	eng := engines.Get().(*engine.Engine)
+	defer func() {
+		// drop references to request data before reusing the engine
+		eng.Reset()
+		engines.Put(eng)
+	}()
+	eng.Limits = limits
+	eng.Logger = requestLogger(r.Context())
+	eng.NoDefaultKey = strictKey
+	eng.Keyring = keyring
+	eng.SpillBytes = spillBytes
+	eng.TempDir = tempDir
+	hasBody := r.Method == "POST" || r.Method == "PUT"
 
-	// Initialize engine and initial value
-	eng := engine.New()
-	if r.Method == "POST" || r.Method == "PUT" {
-		eng.PushStack(b)
+	// initialize variables map, reserving the body until it is read
+	eng.SetReadOnlyVariable("body", nil)
+	for k, v := range secrets {
+		eng.SetSecretVariable(k, v)
 	}
-
-	// initialize variables map
-	eng.SetVariable("body", b)
 	for k, v := range r.Header {
 		if strings.HasPrefix(strings.ToLower(k), prefix) && len(v) > 0 {
-			eng.SetVariable(k[prefixLen:], []byte(v[0]))
+			name := strings.ToLower(k[prefixLen:])
+			if allowedHeaders != nil && !allowedHeaders[name] {
+				writeError(w, r, http.StatusBadRequest, "header", fmt.Errorf("header %s: variable %s cannot be set by a header", k, name))
+				return
+			}
+			err := eng.SetUserVariable(name, []byte(v[0]))
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "header", fmt.Errorf("header %s: %w", k, err))
+				return
+			}
+			if isSecretName(name) {
+				eng.MarkSecret(name)
+			}
 		}
 	}
 
+	// serve help and the API description
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if r.Method == "GET" && isHelpPath(p) {
+		serveHelp(w, r, eng, p)
+		return
+	}
+
 	// check for debug mode
-	if r.URL.Query().Get("debug") != "" {
+	debug := r.URL.Query().Get("debug")
+	if debug != "" {
+		if !canDebug(r) {
+			writeError(w, r, http.StatusForbidden, "debug-forbidden", errors.New("debug mode is not allowed"))
+			return
+		}
 		eng.DebugMode = true
+		if debug == "json" {
+			eng.Tracer = new(engine.JSONTracer)
+		}
 	}
 
 	// process commands
-	p := strings.TrimPrefix(r.URL.Path, "/")
-	var arr []string
-	if p != "" {
-		arr = strings.Split(p, "/")
-	} else {
-		arr = make([]string, 0)
+	prog, err := program(p)
+	if err != nil {
+		_, code := errorStatus(err)
+		writeError(w, r, http.StatusBadRequest, code, err)
+		return
 	}
-	var rb []byte
-	if len(arr) == 0 && r.Method == "GET" {
-		rb = eng.Help()
-	} else {
-		rb, err = eng.Run(arr)
+	// the body variable is not set when streaming
+	if stream && hasBody && !eng.DebugMode && !prog.Loads("body") && eng.Streamable(prog) {
+		streamBody(w, r, eng, prog)
+		return
 	}
+
+	// read body, keeping a large one in a temporary file
+	b, err := eng.ReadValue(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status, code := errorStatus(err)
+		writeError(w, r, status, code, err)
+		return
+	}
+	if hasBody {
+		eng.PushValue(b)
+	}
+	eng.SetReadOnlyValue("body", b)
+
+	rv, err := eng.RunValue(r.Context(), prog)
+	if err != nil {
+		status, code := errorStatus(err)
+		eng.Logger.Warn("pipeline failed", "error", err, "status", status)
+		writeError(w, r, status, code, err)
 		return
 	}
 
 	// Write response
-	_, err = w.Write(rb)
+	if eng.DebugMode && eng.Tracer != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_, err = io.Copy(w, rv.Reader())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// program returns the compiled program for the escaped path, using the cache when possible
+func program(p string) (*engine.Program, error) {
+	if prog, ok := programs.get(p); ok {
+		return prog, nil
+	}
+
+	// split the escaped path so that %2F can be used within a literal
+	var arr []string
+	if p != "" {
+		arr = strings.Split(p, "/")
+		for i := range arr {
+			var err error
+			arr[i], err = url.PathUnescape(arr[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		arr = make([]string, 0)
+	}
+
+	prog, err := engine.Compile(arr)
+	if err != nil {
+		return nil, err
+	}
+	programs.add(p, prog)
+	return prog, nil
+}
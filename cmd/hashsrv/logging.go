@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the ID used to match log lines to a request
+const requestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// setupLogging makes the default logger write to standard error at the given
+// level, as text or JSON
+func setupLogging(level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("log format %q must be text or json", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs an error and exits
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
+// requestID returns the caller's request ID, or a new one if the caller did
+// not send a usable one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID keeps caller-supplied IDs short and safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger returns the logger for a request, which includes its ID
+func requestLogger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// statusWriter records the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// logPipeline returns the pipeline of a request for the access log, with
+// its literals hidden, since they may be keys
+func logPipeline(r *http.Request) string {
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if r.Method == "GET" && isHelpPath(p) {
+		return "/" + p
+	}
+	prog, err := program(p)
+	if err != nil {
+		return "(invalid)"
+	}
+	return prog.Redacted()
+}
+
+// withLogging assigns each request an ID and writes an access log line
+// when it completes
+func withLogging(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		l := slog.Default().With("request", id)
+		sw := &statusWriter{ResponseWriter: w}
+		// log even when the handler aborts the response
+		defer func() {
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			l.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("pipeline", logPipeline(r)),
+				slog.Int("status", sw.status),
+				slog.Int64("bytes", sw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote", r.RemoteAddr))
+		}()
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), loggerKey{}, l)))
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+// secretEnvPrefix marks environment variables that hold named secrets
+const secretEnvPrefix = "HASHSRV_SECRET_"
+
+// loadSecrets gathers the variables configured by the server. The key may
+// come from the key option (which can also be set in the configuration file
+// or the HASHSRV_KEY environment variable) or from a key file. Other named
+// secrets come from the files in the secrets folder and from environment
+// variables starting with HASHSRV_SECRET_.
+func loadSecrets() (map[string][]byte, error) {
+	m := make(map[string][]byte)
+
+	if secretDir != "" {
+		entries, err := os.ReadDir(secretDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			b, err := readSecretFile(filepath.Join(secretDir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			m[strings.ToLower(entry.Name())] = b
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, secretEnvPrefix) && len(k) > len(secretEnvPrefix) {
+			name := strings.ReplaceAll(strings.ToLower(k[len(secretEnvPrefix):]), "_", "-")
+			m[name] = []byte(v)
+		}
+	}
+
+	if keyFile != "" {
+		b, err := readSecretFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		m["key"] = b
+	}
+	if serverKey != "" {
+		m["key"] = []byte(serverKey)
+	}
+	if k, ok := m["key"]; ok && len(k) == 0 {
+		return nil, errors.New("the configured key is empty")
+	}
+
+	// in strict mode, make sure the default key can never be used
+	if strictKey {
+		if _, ok := m["key"]; !ok && allowedHeaders != nil && !allowedHeaders["key"] {
+			return nil, errors.New("strict mode requires a key, because Hashsrv-Key headers are not allowed")
+		}
+	}
+
+	return m, nil
+}
+
+// readSecretFile reads a secret, ignoring a trailing line ending
+func readSecretFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// loadKeyring gathers the keys used by protect and unprotect
+func loadKeyring() (*engine.Keyring, error) {
+	if keyringDir == "" && keyringSpec == "" {
+		return nil, nil
+	}
+	k := engine.NewKeyring()
+	if keyringDir != "" {
+		if err := k.LoadDir(keyringDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.LoadSpec(keyringSpec); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
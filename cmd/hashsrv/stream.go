@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ancientlore/hashsrv/engine"
+)
+
+// streamBody runs the program on the request body without reading all of it
+// into memory, streaming the result back
+func streamBody(w http.ResponseWriter, r *http.Request, eng *engine.Engine, prog *engine.Program) {
+	cw := &countingWriter{w: w}
+	err := eng.RunStream(r.Context(), prog, r.Body, cw)
+	if err == nil {
+		return
+	}
+	status, code := errorStatus(err)
+	eng.Logger.Warn("pipeline failed", "error", err, "status", status, "stream", true)
+	if cw.n == 0 {
+		writeError(w, r, status, code, err)
+		return
+	}
+	// part of the result was sent, so drop the connection to show the
+	// client that it is incomplete
+	panic(http.ErrAbortHandler)
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	w http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// bitwise pops two values of the same length and pushes f applied to each pair of bytes
+func (e *Engine) bitwise(op string, f func(a, b byte) byte) error {
+	val2 := e.stack.Pop()
+	val1 := e.stack.Pop()
+	if val1 == nil || val2 == nil {
+		return fmt.Errorf("%s: expected 2 values on the stack", op)
+	}
+	if len(val1) != len(val2) {
+		return invalidArg(op, "values have different lengths (%d and %d)", len(val1), len(val2))
+	}
+	r := make([]byte, len(val1))
+	for i := range r {
+		r[i] = f(val1[i], val2[i])
+	}
+	e.stack.Push(r)
+	return nil
+}
+
+func (e *Engine) xor() error {
+	return e.bitwise("xor", func(a, b byte) byte { return a ^ b })
+}
+
+func (e *Engine) and() error {
+	return e.bitwise("and", func(a, b byte) byte { return a & b })
+}
+
+func (e *Engine) or() error {
+	return e.bitwise("or", func(a, b byte) byte { return a | b })
+}
+
+func (e *Engine) not() error {
+	b := e.stack.Pop()
+	if b == nil {
+		return errors.New("not: expected 1 value on the stack")
+	}
+	r := make([]byte, len(b))
+	for i := range b {
+		r[i] = ^b[i]
+	}
+	e.stack.Push(r)
+	return nil
+}
+
+func (e *Engine) reverse() error {
+	b := e.stack.Pop()
+	if b == nil {
+		return errors.New("reverse: expected 1 value on the stack")
+	}
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	e.stack.Push(r)
+	return nil
+}
+
+func (e *Engine) repeat() error {
+	count, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	b := e.stack.Pop()
+	if b == nil {
+		return errors.New("repeat: expected 2 values on the stack")
+	}
+	if count < 0 {
+		return invalidArg("repeat", "count cannot be negative")
+	}
+	if len(b) > 0 && count > maxInt/len(b) {
+		return invalidArg("repeat", "result is too large")
+	}
+	if err = e.checkSize(len(b) * count); err != nil {
+		return err
+	}
+	e.stack.Push(bytes.Repeat(b, count))
+	return nil
+}
+
+func (e *Engine) zeros() error {
+	count, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return invalidArg("zeros", "count cannot be negative")
+	}
+	if err = e.checkSize(count); err != nil {
+		return err
+	}
+	e.stack.Push(make([]byte, count))
+	return nil
+}
+
+// pad pops the data, length, and fill byte, and pushes the data padded to
+// the length on the left or right. Data that is long enough is left alone.
+func (e *Engine) pad(op string, left bool) error {
+	fill := e.stack.Pop()
+	length, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	b := e.stack.Pop()
+	if fill == nil || b == nil {
+		return fmt.Errorf("%s: expected 3 values on the stack", op)
+	}
+	if len(fill) != 1 {
+		return invalidArg(op, "fill must be a single byte, not %d bytes", len(fill))
+	}
+	if len(b) >= length {
+		e.stack.Push(b)
+		return nil
+	}
+	if err = e.checkSize(length); err != nil {
+		return err
+	}
+	r := bytes.Repeat(fill, length)
+	if left {
+		copy(r[length-len(b):], b)
+	} else {
+		copy(r, b)
+	}
+	e.stack.Push(r)
+	return nil
+}
+
+func (e *Engine) pad_left() error {
+	return e.pad("pad-left", true)
+}
+
+func (e *Engine) pad_right() error {
+	return e.pad("pad-right", false)
+}
+
+func (e *Engine) byte_at() error {
+	i, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	b := e.stack.Pop()
+	if b == nil {
+		return errors.New("byte-at: expected 2 values on the stack")
+	}
+	if i < 0 || i >= len(b) {
+		return invalidArg("byte-at", "index %d out of range", i)
+	}
+	e.stack.Push([]byte(strconv.Itoa(int(b[i]))))
+	return nil
+}
+
+const maxInt = int(^uint(0) >> 1)
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Example shows how a function is used. Pipeline is the URL path, Input is
+// the request body (empty for a GET), and Output is the result.
+type Example struct {
+	Pipeline string `json:"pipeline"`
+	Input    string `json:"input,omitempty"`
+	Output   string `json:"output"`
+}
+
+// FuncDoc describes a function for the help catalog.
+type FuncDoc struct {
+	Name     string    `json:"name"`
+	Category string    `json:"category"`
+	In       string    `json:"in"`
+	Out      string    `json:"out"`
+	Desc     string    `json:"description"`
+	Examples []Example `json:"examples,omitempty"`
+}
+
+// Catalog describes the functions available to this engine, sorted by
+// category and then by name.
+func (e *Engine) Catalog() []FuncDoc {
+	fns := e.functions()
+	docs := make([]FuncDoc, 0, len(fns))
+	for name, fd := range fns {
+		docs = append(docs, newFuncDoc(name, fd))
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Category != docs[j].Category {
+			return docs[i].Category < docs[j].Category
+		}
+		return docs[i].Name < docs[j].Name
+	})
+	return docs
+}
+
+// Describe returns the description of the named function.
+func (e *Engine) Describe(name string) (FuncDoc, bool) {
+	if fd, ok := e.funcs[name]; ok {
+		return newFuncDoc(name, fd), true
+	}
+	if fd, ok := lookupFunc(name); ok {
+		return newFuncDoc(name, fd), true
+	}
+	return FuncDoc{}, false
+}
+
+// HelpFunc returns a page describing the named function, or nil if there is
+// no such function.
+func (e *Engine) HelpFunc(name string) []byte {
+	doc, ok := e.Describe(name)
+	if !ok {
+		return nil
+	}
+	var b bytes.Buffer
+	b.WriteString(htmlHeader)
+	tpl.ExecuteTemplate(&b, "Func", doc)
+	b.WriteString(htmlFooter)
+	return b.Bytes()
+}
+
+func newFuncDoc(name string, fd funcInfo) FuncDoc {
+	category := fd.Category
+	if category == "" {
+		category = "Other"
+	}
+	return FuncDoc{Name: name, Category: category, In: fd.In, Out: fd.Out, Desc: fd.Desc, Examples: fd.Examples}
+}
+
+// initExamples adds the examples to the built-in functions
+func initExamples() {
+	for name, ex := range funcExamples {
+		fd := funcMap[name]
+		fd.Examples = ex
+		funcMap[name] = fd
+	}
+}
+
+// funcExamples holds the examples for the built-in functions. They are
+// checked by the tests, so keep the output up to date.
+var funcExamples = map[string][]Example{
+	// hashing
+	"md5":         {{Pipeline: "/md5/hex", Input: "Hello", Output: "8b1a9953c4611296a827abf8c47804d7"}},
+	"sha1":        {{Pipeline: "/sha1/hex", Input: "Hello", Output: "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0"}},
+	"sha256":      {{Pipeline: "/sha256/hex", Input: "Hello", Output: "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"}},
+	"sha512":      {{Pipeline: "/sha512/hex", Input: "Hello", Output: "3615f80c9d293ed7402687f94b22d58e529b8cc7916f8fac7fddf7fbd5af4cf777d3d795a7a00a16bf7e7f3fb9561ee9baae480da9fe7a18769e71886b03f315"}},
+	"ripemd160":   {{Pipeline: "/ripemd160/hex", Input: "Hello", Output: "d44426aca8ae0a69cdbc4021c64fa5ad68ca32fe"}},
+	"rand":        {{Pipeline: "/16/rand/len/swap/pop", Output: "16"}},
+	"sha256-len":  {{Pipeline: "/sha256-len", Output: "32"}},
+	"sha3-256":    {{Pipeline: "/sha3-256/hex", Input: "Hello", Output: "8ca66ee6b2fe4bb928a8e3cd2f508de4119c0895f22e011117e22cf9b13de7ef"}},
+	"sha512-256":  {{Pipeline: "/sha512-256/hex", Input: "Hello", Output: "7e75b18b88d2cb8be95b05ec611e54e2460408a2dcf858f945686446c9d07aac"}},
+	"blake2b-256": {{Pipeline: "/blake2b-256/hex", Input: "Hello", Output: "8b7ca7d27d9fc55fa30abfe515b3afb24e3fe89fdd02e2ac92bca2c96680642e"}},
+	"blake2s-256": {{Pipeline: "/blake2s-256/hex", Input: "Hello", Output: "f73a5fbf881f89b814871f46e26ad3fa37cb2921c5e8561618639015b3ccbb71"}},
+	"shake128":    {{Pipeline: "/10/shake128/hex", Input: "Hello", Output: "4131f8db5745776b48b8"}},
+
+	// HMAC hashing
+	"hmac-sha256":     {{Pipeline: "/MyKey/hmac-sha256/hex", Input: "Hello", Output: "fd2648d5187786cfcc454d9598e62b90c3f5f3402debc0201a777a02e8fb3aa1"}},
+	"hmac-sha512":     {{Pipeline: "/MyKey/sha512/hmac-sha512/base64-url", Input: "Hello", Output: "40ZpPq5cMIYCxcdbjWDJYavegiV_K64p6Ni63D5BwDBzx3DE-Yb__kv69xpq28943ykbbInz_XrBNfwkR8psSQ=="}},
+	"hmac-sha3-256":   {{Pipeline: "/MyKey/hmac-sha3-256/hex", Input: "Hello", Output: "46f2c69e7ee81439ab61d4084f355a5b0c81e41c9a8a011dabf7a1e9676260e2"}},
+	"mac-blake2b-256": {{Pipeline: "/MyKey/mac-blake2b-256/hex", Input: "Hello", Output: "013311d497d1327d7b08567695ccb26643996a74d901416010499c9efe8cf800"}},
+
+	// encoding
+	"hex":          {{Pipeline: "/hex", Input: "Hello", Output: "48656c6c6f"}},
+	"unhex":        {{Pipeline: "/unhex", Input: "48656c6c6f", Output: "Hello"}},
+	"base32":       {{Pipeline: "/base32", Input: "Hello", Output: "JBSWY3DP"}},
+	"base64":       {{Pipeline: "/base64", Input: "Hello", Output: "SGVsbG8="}},
+	"unbase64":     {{Pipeline: "/unbase64", Input: "SGVsbG8=", Output: "Hello"}},
+	"base64-url":   {{Pipeline: "/base64-url", Input: "Hello?>", Output: "SGVsbG8_Pg=="}},
+	"ascii85":      {{Pipeline: "/ascii85", Input: "Hello", Output: "87cURDZ"}},
+	"unbase64-url": {{Pipeline: "/unbase64-url", Input: "SGVsbG8_Pg==", Output: "Hello?>"}},
+
+	// checksum hashing
+	"adler32": {{Pipeline: "/adler32/hex", Input: "Hello", Output: "058c01f5"}},
+	"crc32":   {{Pipeline: "/crc32/hex", Input: "Hello", Output: "f7d18982"}},
+	"fnv64a":  {{Pipeline: "/fnv64a/hex", Input: "Hello", Output: "63f0bfacf2c00f6b"}},
+
+	// compression
+	"snappy": {{Pipeline: "/snappy/unsnappy", Input: "Hello", Output: "Hello"}},
+	"gzip":   {{Pipeline: "/9/gzip/ungzip", Input: "Hello", Output: "Hello"}},
+	"zlib":   {{Pipeline: "/zlib/unzlib", Input: "Hello", Output: "Hello"}},
+
+	// control commands
+	"push":   {{Pipeline: "/push/append", Input: "Hello", Output: "HelloHello"}},
+	"pop":    {{Pipeline: "/World/pop", Input: "Hello", Output: "Hello"}},
+	"swap":   {{Pipeline: "/World/swap/append", Input: "Hello", Output: "WorldHello"}},
+	"over":   {{Pipeline: "/'-/over/append/append", Input: "Hello", Output: "Hello-Hello"}},
+	"append": {{Pipeline: "/'%20World/append", Input: "Hello", Output: "Hello World"}},
+	"slice":  {{Pipeline: "/1/3/slice", Input: "Hello", Output: "el"}, {Pipeline: "/2/-1/slice", Input: "Hello", Output: "llo"}},
+	"len":    {{Pipeline: "/len/swap/pop", Input: "Hello", Output: "5"}},
+	"left":   {{Pipeline: "/2/left", Input: "Hello", Output: "He"}},
+	"right":  {{Pipeline: "/2/right", Input: "Hello", Output: "lo"}},
+	"snip":   {{Pipeline: "/2/snip/swap/pop", Input: "Hello", Output: "llo"}},
+	"depth":  {{Pipeline: "/depth/swap/pop", Input: "Hello", Output: "1"}},
+	"pick":   {{Pipeline: "/World/1/pick/append/append", Input: "Hello", Output: "HelloWorldHello"}},
+	"load":   {{Pipeline: "/key/load/len/swap/pop", Output: "46"}},
+	"call":   {{Pipeline: "/sign-sha256/call/hex", Input: "Hello", Output: "5c92e5272e5c44bf366156d43a006d85ef1a75d3d65e42db972f387a25c1800f48656c6c6f"}},
+	"if":     {{Pipeline: "/0/nop/nop/if", Input: "Hello", Output: "Hello"}},
+	"ct-eq?": {{Pipeline: "/Hello/ct-eq%3F", Input: "Hello", Output: "1"}},
+	"eq":     {{Pipeline: "/push/eq/'same", Input: "Hello", Output: "same"}},
+
+	// text
+	"lower":         {{Pipeline: "/lower", Input: "Hello", Output: "hello"}},
+	"upper":         {{Pipeline: "/upper", Input: "Hello", Output: "HELLO"}},
+	"trim":          {{Pipeline: "/trim", Input: "  Hello  ", Output: "Hello"}},
+	"replace":       {{Pipeline: "/l/L/replace", Input: "Hello", Output: "HeLLo"}},
+	"regex-match":   {{Pipeline: "/'%5EH.*o%24/regex-match", Input: "Hello", Output: "1"}},
+	"regex-replace": {{Pipeline: "/'%5Baeiou%5D/'*/regex-replace", Input: "Hello", Output: "H*ll*"}},
+	"split":         {{Pipeline: "/'l/split/'-/join", Input: "Hello", Output: "He--o"}},
+
+	// bitwise and byte-level
+	"xor":       {{Pipeline: "/hex:2020202020/xor", Input: "Hello", Output: "hELLO"}},
+	"not":       {{Pipeline: "/not/hex", Input: "Hello", Output: "b79a939390"}},
+	"reverse":   {{Pipeline: "/reverse", Input: "Hello", Output: "olleH"}},
+	"repeat":    {{Pipeline: "/3/repeat", Input: "ab", Output: "ababab"}},
+	"pad-left":  {{Pipeline: "/8/*/pad-left", Input: "Hello", Output: "***Hello"}},
+	"pad-right": {{Pipeline: "/8/*/pad-right", Input: "Hello", Output: "Hello***"}},
+	"zeros":     {{Pipeline: "/4/zeros/hex", Output: "00000000"}},
+	"byte-at":   {{Pipeline: "/0/byte-at", Input: "Hello", Output: "72"}},
+
+	// arithmetic
+	"add": {{Pipeline: "/2/3/add", Output: "5"}},
+	"sub": {{Pipeline: "/2/3/sub", Output: "-1"}},
+	"mul": {{Pipeline: "/6/7/mul", Output: "42"}},
+	"div": {{Pipeline: "/7/2/div", Output: "3"}},
+	"mod": {{Pipeline: "/7/2/mod", Output: "1"}},
+	"lt":  {{Pipeline: "/2/3/lt", Output: "1"}},
+	"inc": {{Pipeline: "/41/inc", Output: "42"}},
+
+	// crypto
+	"aes-blocksize": {{Pipeline: "/aes-blocksize", Output: "16"}},
+	"aes-cfb":       {{Pipeline: "/encrypt-aes/call/decrypt-aes/call", Input: "Hello", Output: "Hello"}},
+}
@@ -8,7 +8,6 @@ import (
 	"compress/lzw"
 	"compress/zlib"
 	"io"
-	"io/ioutil"
 
 	"github.com/golang/snappy"
 )
@@ -20,17 +19,25 @@ func (e *Engine) snappy() error {
 }
 
 func (e *Engine) unsnappy() error {
-	data, err := snappy.Decode(nil, e.stack.Pop())
+	src := e.stack.Pop()
+	n, err := snappy.DecodedLen(src)
+	if err == nil {
+		err = e.checkSize(n)
+	}
+	var data []byte
+	if err == nil {
+		data, err = snappy.Decode(nil, src)
+	}
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("snappy", err)
 }
 
 func (e *Engine) zlib() error {
 	var buf bytes.Buffer
 	w := zlib.NewWriter(&buf)
-	_, err := w.Write(e.stack.Pop())
+	err := e.write(w, e.stack.Pop())
 	w.Close()
 	if err == nil {
 		e.stack.Push(buf.Bytes())
@@ -45,13 +52,13 @@ func (e *Engine) unzlib() error {
 	var data []byte
 	r, err = zlib.NewReader(buf)
 	if err == nil {
-		data, err = ioutil.ReadAll(r)
+		data, err = e.readAll(r)
 		r.Close()
 	}
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("zlib", err)
 }
 
 func (e *Engine) deflate() error {
@@ -63,7 +70,7 @@ func (e *Engine) deflate() error {
 		var w *flate.Writer
 		w, err = flate.NewWriter(&buf, level)
 		if err == nil {
-			_, err = w.Write(e.stack.Pop())
+			err = e.write(w, e.stack.Pop())
 			w.Close()
 		}
 	}
@@ -76,12 +83,12 @@ func (e *Engine) deflate() error {
 func (e *Engine) inflate() error {
 	buf := bytes.NewBuffer(e.stack.Pop())
 	r := flate.NewReader(buf)
-	data, err := ioutil.ReadAll(r)
+	data, err := e.readAll(r)
 	r.Close()
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("deflate", err)
 }
 
 func (e *Engine) gzip() error {
@@ -93,7 +100,7 @@ func (e *Engine) gzip() error {
 		var w *gzip.Writer
 		w, err = gzip.NewWriterLevel(&buf, level)
 		if err == nil {
-			_, err = w.Write(e.stack.Pop())
+			err = e.write(w, e.stack.Pop())
 			w.Close()
 		}
 	}
@@ -110,23 +117,23 @@ func (e *Engine) ungzip() error {
 	var err error
 	r, err = gzip.NewReader(buf)
 	if err == nil {
-		data, err = ioutil.ReadAll(r)
+		data, err = e.readAll(r)
 		r.Close()
 	}
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("gzip", err)
 }
 
 func (e *Engine) unbzip2() error {
 	buf := bytes.NewBuffer(e.stack.Pop())
 	r := bzip2.NewReader(buf)
-	data, err := ioutil.ReadAll(r)
+	data, err := e.readAll(r)
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("bzip2", err)
 }
 
 func (e *Engine) lzw_msb() error {
@@ -136,7 +143,7 @@ func (e *Engine) lzw_msb() error {
 	litWidth, err = e.stack.PopInt()
 	if err == nil {
 		w := lzw.NewWriter(&buf, lzw.MSB, litWidth)
-		_, err = w.Write(e.stack.Pop())
+		err = e.write(w, e.stack.Pop())
 		w.Close()
 	}
 	if err == nil {
@@ -152,7 +159,7 @@ func (e *Engine) lzw_lsb() error {
 	litWidth, err = e.stack.PopInt()
 	if err == nil {
 		w := lzw.NewWriter(&buf, lzw.LSB, litWidth)
-		_, err = w.Write(e.stack.Pop())
+		err = e.write(w, e.stack.Pop())
 		w.Close()
 	}
 	if err == nil {
@@ -169,13 +176,13 @@ func (e *Engine) unlzw_msb() error {
 	if err == nil {
 		buf := bytes.NewBuffer(e.stack.Pop())
 		r := lzw.NewReader(buf, lzw.MSB, litWidth)
-		data, err = ioutil.ReadAll(r)
+		data, err = e.readAll(r)
 		r.Close()
 	}
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("lzw", err)
 }
 
 func (e *Engine) unlzw_lsb() error {
@@ -186,11 +193,11 @@ func (e *Engine) unlzw_lsb() error {
 	if err == nil {
 		buf := bytes.NewBuffer(e.stack.Pop())
 		r := lzw.NewReader(buf, lzw.LSB, litWidth)
-		data, err = ioutil.ReadAll(r)
+		data, err = e.readAll(r)
 		r.Close()
 	}
 	if err == nil {
 		e.stack.Push(data)
 	}
-	return err
+	return decodeError("lzw", err)
 }
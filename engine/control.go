@@ -2,23 +2,25 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"strings"
 )
 
 func (e *Engine) push() error {
-	b := e.stack.Top()
-	if b == nil {
+	v := e.stack.PeekValue(0)
+	if v == nil {
 		return errors.New("push - no value")
 	}
-	e.stack.Push(b)
+	e.stack.PushValue(v)
 	return nil
 }
 
 func (e *Engine) pop() error {
-	b := e.stack.Pop()
-	if b == nil {
+	v := e.stack.PopValue()
+	if v == nil {
 		return errors.New("pop: Stack empty")
 	}
 	return nil
@@ -28,38 +30,39 @@ func (e *Engine) load() error {
 	var str string
 	str, err := e.stack.PopString()
 	if err == nil {
-		v := e.GetVariable(str)
+		v := e.variable(strings.ToLower(str))
 		if v == nil {
-			err = errors.New("nil or no value called " + str)
+			err = invalidArg("load", "nil or no value called %s", str)
+		} else if e.NoDefaultKey && strings.ToLower(str) == "key" && e.valueIs("key", []byte(defaultKey)) {
+			err = ErrDefaultKey
 		} else {
-			e.stack.Push(v)
+			e.stack.PushValue(v)
 		}
 	}
 	return err
 }
 
 func (e *Engine) save() error {
+	// save doesn't load the value it saves, but needs the name in memory
+	if err := e.loadValues(1); err != nil {
+		return err
+	}
 	var str string
 	str, err := e.stack.PopString()
 	if err == nil {
-		v := e.stack.Pop()
+		v := e.stack.PopValue()
 		if v == nil {
 			err = errors.New("cannot save - stack empty")
 		} else {
-			e.SetVariable(str, v)
+			err = e.setUserValue(str, v)
 		}
 	}
 	return err
 }
 
 func (e *Engine) swap() error {
-	bs2 := e.stack.Pop()
-	bs1 := e.stack.Pop()
-	if bs1 == nil || bs2 == nil {
+	if !e.stack.Roll(1) {
 		return errors.New("swap: expected 2 values on stack")
-	} else {
-		e.stack.Push(bs2)
-		e.stack.Push(bs1)
 	}
 	return nil
 }
@@ -70,9 +73,132 @@ func (e *Engine) append() error {
 	if bs1 == nil || bs2 == nil {
 		return errors.New("append: expected 2 values on stack")
 	} else {
-		bs1 = append(bs1, bs2...)
-		e.stack.Push(bs1)
+		// always copy, as values may be shared with literals and variables
+		b := make([]byte, 0, len(bs1)+len(bs2))
+		b = append(b, bs1...)
+		b = append(b, bs2...)
+		e.stack.Push(b)
+	}
+	return nil
+}
+
+// need returns an error unless the stack holds at least n values
+func (e *Engine) need(op string, n int) error {
+	if e.stack.Len() < n {
+		return &StackUnderflowError{Op: op, Need: n, Have: e.stack.Len()}
+	}
+	return nil
+}
+
+func (e *Engine) over() error {
+	if err := e.need("over", 2); err != nil {
+		return err
+	}
+	e.stack.PushValue(e.stack.PeekValue(1))
+	return nil
+}
+
+func (e *Engine) rot() error {
+	if err := e.need("rot", 3); err != nil {
+		return err
+	}
+	e.stack.Roll(2)
+	return nil
+}
+
+func (e *Engine) unrot() error {
+	if err := e.need("-rot", 3); err != nil {
+		return err
 	}
+	e.stack.Roll(2)
+	e.stack.Roll(2)
+	return nil
+}
+
+func (e *Engine) nip() error {
+	if err := e.need("nip", 2); err != nil {
+		return err
+	}
+	e.stack.Roll(1)
+	e.stack.PopValue()
+	return nil
+}
+
+func (e *Engine) tuck() error {
+	if err := e.need("tuck", 2); err != nil {
+		return err
+	}
+	e.stack.Roll(1)
+	e.stack.PushValue(e.stack.PeekValue(1))
+	return nil
+}
+
+func (e *Engine) pick() error {
+	if err := e.loadValues(1); err != nil {
+		return err
+	}
+	n, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return invalidArg("pick", "position %d is negative", n)
+	}
+	v := e.stack.PeekValue(n)
+	if v == nil {
+		return &StackUnderflowError{Op: "pick", Need: n + 1, Have: e.stack.Len()}
+	}
+	e.stack.PushValue(v)
+	return nil
+}
+
+func (e *Engine) roll() error {
+	if err := e.loadValues(1); err != nil {
+		return err
+	}
+	n, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return invalidArg("roll", "position %d is negative", n)
+	}
+	if !e.stack.Roll(n) {
+		return &StackUnderflowError{Op: "roll", Need: n + 1, Have: e.stack.Len()}
+	}
+	return nil
+}
+
+func (e *Engine) depth_() error {
+	n := e.stack.Len()
+	if e.piped {
+		// the stream counts as a value
+		n++
+	}
+	e.stack.Push([]byte(fmt.Sprintf("%d", n)))
+	return nil
+}
+
+func (e *Engine) clear() error {
+	e.stack.Clear()
+	return nil
+}
+
+func (e *Engine) dup2() error {
+	if err := e.need("2dup", 2); err != nil {
+		return err
+	}
+	e.stack.PushValue(e.stack.PeekValue(1))
+	e.stack.PushValue(e.stack.PeekValue(1))
+	return nil
+}
+
+func (e *Engine) swap2() error {
+	if err := e.need("2swap", 4); err != nil {
+		return err
+	}
+	e.stack.Roll(3)
+	e.stack.Roll(3)
 	return nil
 }
 
@@ -89,12 +215,12 @@ func (e *Engine) slice() error {
 				err = errors.New("slice: expected 3 values on the stack")
 			} else {
 				if start > len(d) || end > len(d) {
-					err = errors.New("out of range")
+					err = invalidArg("slice", "out of range")
 				} else if end < 0 && start < 0 {
 					// do nothing
 				} else if end >= 0 && start >= 0 {
 					if start > end {
-						err = errors.New("start greater than end")
+						err = invalidArg("slice", "start greater than end")
 					} else {
 						d = d[start:end]
 					}
@@ -139,11 +265,11 @@ func (e *Engine) right() error {
 }
 
 func (e *Engine) len() error {
-	b := e.stack.Top()
-	if b == nil {
+	v := e.stack.PeekValue(0)
+	if v == nil {
 		return errors.New("len: expected 1 value on stack")
 	}
-	e.stack.Push([]byte(fmt.Sprintf("%d", len(b))))
+	e.stack.Push([]byte(fmt.Sprintf("%d", v.Len())))
 	return nil
 }
 
@@ -180,7 +306,7 @@ func (e *Engine) eq() error {
 		return errors.New("eq: expected 2 values on the stack")
 	}
 	if !bytes.Equal(val1, val2) {
-		return errors.New("values not equal")
+		return &VerificationFailedError{Reason: "values not equal"}
 	}
 	return nil
 }
@@ -192,29 +318,233 @@ func (e *Engine) neq() error {
 		return errors.New("neq: expected 2 values on the stack")
 	}
 	if bytes.Equal(val1, val2) {
-		return errors.New("values not expected to be equal")
+		return &VerificationFailedError{Reason: "values not expected to be equal"}
 	}
 	return nil
 }
 
+// ctEqual pops two values and compares them in constant time
+func (e *Engine) ctEqual(op string) (bool, error) {
+	val1 := e.stack.Pop()
+	val2 := e.stack.Pop()
+	if val1 == nil || val2 == nil {
+		return false, fmt.Errorf("%s: expected 2 values on the stack", op)
+	}
+	return subtle.ConstantTimeCompare(val1, val2) == 1, nil
+}
+
+func (e *Engine) ct_eq() error {
+	ok, err := e.ctEqual("ct-eq")
+	if err == nil && !ok {
+		err = &VerificationFailedError{Reason: "values not equal"}
+	}
+	return err
+}
+
+func (e *Engine) ct_eq_flag() error {
+	ok, err := e.ctEqual("ct-eq?")
+	if err == nil {
+		if ok {
+			e.stack.Push([]byte("1"))
+		} else {
+			e.stack.Push([]byte("0"))
+		}
+	}
+	return err
+}
+
 func (e *Engine) call() error {
 	nm, err := e.stack.PopString()
 	if err != nil {
 		return err
 	}
+	return e.callNamed(nm)
+}
 
-	f, ok := e.values[nm]
+// callNamed executes the commands stored in the named dictionary value
+func (e *Engine) callNamed(nm string) error {
+	p, err := e.lookupProgram("call", nm)
+	if err != nil {
+		return err
+	}
+	return e.execProgram(p)
+}
+
+// lookupProgram compiles the commands stored in the named dictionary value,
+// naming op in the error when there is no such value
+func (e *Engine) lookupProgram(op, nm string) (*Program, error) {
+	f, ok, err := e.value(nm)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
-		return fmt.Errorf("call: cannot find %s", nm)
+		return nil, invalidArg(op, "cannot find %s", nm)
 	}
+	return Compile(parseCommands(string(f)))
+}
 
-	p := strings.TrimPrefix(string(f), "/")
-	var commands []string
-	if p != "" {
-		commands = strings.Split(p, "/")
-	} else {
-		commands = make([]string, 0)
+// parseCommands splits a stored program like /md5/hex into its commands
+func parseCommands(p string) []string {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return make([]string, 0)
+	}
+	return strings.Split(p, "/")
+}
+
+func (e *Engine) foreach() error {
+	nm, err := e.stack.PopString()
+	if err != nil {
+		return err
+	}
+	data := e.stack.Pop()
+	if data == nil {
+		return errors.New("foreach: expected 2 values on the stack")
 	}
+	return e.each("foreach", data, []byte("\n"), nm, "line")
+}
+
+func (e *Engine) foreach_split() error {
+	nm, err := e.stack.PopString()
+	if err != nil {
+		return err
+	}
+	sep := e.stack.Pop()
+	data := e.stack.Pop()
+	if sep == nil || data == nil {
+		return errors.New("foreach-split: expected 3 values on the stack")
+	}
+	if len(sep) == 0 {
+		return invalidArg("foreach-split", "delimiter is empty")
+	}
+	return e.each("foreach-split", data, sep, nm, "element")
+}
 
-	return e.exec(commands)
+// each splits data on sep and runs the named program once per element. Each
+// run gets its own stack holding only the element and must leave exactly one
+// value, and the results are joined back together using sep. The results
+// count toward MaxTotalBytes as they are collected.
+func (e *Engine) each(op string, data, sep []byte, nm string, unit string) error {
+	if len(data) == 0 {
+		e.stack.Push(data)
+		return nil
+	}
+
+	items := bytes.Split(data, sep)
+	trailing := len(items) > 1 && len(items[len(items)-1]) == 0
+	if trailing {
+		items = items[:len(items)-1]
+	}
+
+	p, err := e.lookupProgram(op, nm)
+	if err != nil {
+		return err
+	}
+
+	saved := e.stack
+	defer func() { e.stack = saved }()
+
+	results := make([][]byte, 0, len(items))
+	size := 0 // the combined size of the results
+	for i, item := range items {
+		if unit == "line" {
+			item = bytes.TrimSuffix(item, []byte("\r"))
+		}
+		e.stack = NewStack()
+		e.stack.Push(item)
+		err = e.execProgram(p)
+		if err != nil {
+			return fmt.Errorf("%s: %s %d: %w", op, unit, i+1, err)
+		}
+		if n := e.stack.Len(); n == 0 {
+			return fmt.Errorf("%s: %s %d: %w", op, unit, i+1, &StackUnderflowError{Op: nm, Need: 1})
+		} else if n > 1 {
+			return fmt.Errorf("%s: %s %d: %w", op, unit, i+1, &UnusedValuesError{Op: nm, Left: n})
+		}
+		r := e.stack.Pop()
+		size += len(r)
+		if e.Limits.MaxTotalBytes > 0 && saved.size+e.varSize+size > e.Limits.MaxTotalBytes {
+			return &LimitExceededError{Err: ErrTotalBytes, Limit: int64(e.Limits.MaxTotalBytes)}
+		}
+		results = append(results, r)
+	}
+
+	out := bytes.Join(results, sep)
+	if trailing {
+		out = append(out, sep...)
+	}
+	saved.Push(out)
+	return nil
+}
+
+// truth reports whether a value counts as true for the conditional words:
+// anything other than an empty value or "0"
+func truth(b []byte) bool {
+	return len(b) > 0 && string(b) != "0"
+}
+
+func (e *Engine) branch(op string, cond func(val1, val2 []byte) bool) error {
+	elseName := e.stack.Pop()
+	thenName := e.stack.Pop()
+	val1 := e.stack.Pop()
+	val2 := e.stack.Pop()
+	if elseName == nil || thenName == nil || val1 == nil || val2 == nil {
+		return fmt.Errorf("%s: expected 4 values on the stack", op)
+	}
+	if cond(val1, val2) {
+		return e.callNamed(string(thenName))
+	}
+	return e.callNamed(string(elseName))
+}
+
+func (e *Engine) if_() error {
+	elseName := e.stack.Pop()
+	thenName := e.stack.Pop()
+	flag := e.stack.Pop()
+	if elseName == nil || thenName == nil || flag == nil {
+		return errors.New("if: expected 3 values on the stack")
+	}
+	if truth(flag) {
+		return e.callNamed(string(thenName))
+	}
+	return e.callNamed(string(elseName))
+}
+
+func (e *Engine) ifeq() error {
+	return e.branch("ifeq", bytes.Equal)
+}
+
+func (e *Engine) ifneq() error {
+	return e.branch("ifneq", func(val1, val2 []byte) bool { return !bytes.Equal(val1, val2) })
+}
+
+func (e *Engine) try() error {
+	handler, err := e.stack.PopString()
+	if err != nil {
+		return err
+	}
+	nm, err := e.stack.PopString()
+	if err != nil {
+		return err
+	}
+
+	saved := e.stack.Clone()
+	err = e.callNamed(nm)
+	if err == nil {
+		return nil
+	}
+
+	// limits and cancellation are not something a handler can recover from
+	var le *LimitExceededError
+	if errors.As(err, &le) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	// restore the stack and let the handler see what went wrong
+	e.Logf("try: %s failed: %s", nm, err)
+	e.stack = saved
+	if err := e.SetUserVariable("error", []byte(cause(err).Error())); err != nil {
+		return err
+	}
+	return e.callNamed(handler)
 }
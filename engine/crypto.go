@@ -41,7 +41,7 @@ func (e *Engine) cfb(cipherBlock func(key []byte) (cipher.Block, error)) error {
 	if key == nil || iv == nil || plaintext == nil {
 		return errors.New("expected data, IV, and key on the stack")
 	}
-	block, err := cipherBlock(key)
+	block, err := newBlock(cipherBlock, key, iv)
 	if err != nil {
 		return err
 	}
@@ -59,7 +59,7 @@ func (e *Engine) uncfb(cipherBlock func(key []byte) (cipher.Block, error)) error
 	if key == nil || iv == nil || ciphertext == nil {
 		return errors.New("expected data, IV, and key on the stack")
 	}
-	block, err := cipherBlock(key)
+	block, err := newBlock(cipherBlock, key, iv)
 	if err != nil {
 		return err
 	}
@@ -77,7 +77,7 @@ func (e *Engine) ofb(cipherBlock func(key []byte) (cipher.Block, error)) error {
 	if key == nil || iv == nil || text1 == nil {
 		return errors.New("expected data, IV, and key on the stack")
 	}
-	block, err := cipherBlock(key)
+	block, err := newBlock(cipherBlock, key, iv)
 	if err != nil {
 		return err
 	}
@@ -95,7 +95,7 @@ func (e *Engine) ctr(cipherBlock func(key []byte) (cipher.Block, error)) error {
 	if key == nil || iv == nil || text1 == nil {
 		return errors.New("expected data, IV, and key on the stack")
 	}
-	block, err := cipherBlock(key)
+	block, err := newBlock(cipherBlock, key, iv)
 	if err != nil {
 		return err
 	}
@@ -106,6 +106,18 @@ func (e *Engine) ctr(cipherBlock func(key []byte) (cipher.Block, error)) error {
 	return nil
 }
 
+// newBlock creates the cipher, checking the key and IV sizes
+func newBlock(cipherBlock func(key []byte) (cipher.Block, error), key, iv []byte) (cipher.Block, error) {
+	block, err := cipherBlock(key)
+	if err != nil {
+		return nil, &InvalidArgumentError{Op: "cipher", Err: err}
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, invalidArg("cipher", "IV must be %d bytes, not %d", block.BlockSize(), len(iv))
+	}
+	return block, nil
+}
+
 // CFB
 
 func (e *Engine) aes_cfb() error {
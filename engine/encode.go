@@ -22,7 +22,7 @@ func (e *Engine) unhex() error {
 	if err == nil {
 		e.stack.Push(dec[0:n])
 	}
-	return err
+	return decodeError("hex", err)
 }
 
 func (e *Engine) ascii85() error {
@@ -40,7 +40,7 @@ func (e *Engine) unascii85() error {
 	if err == nil {
 		e.stack.Push(dec[0:sz])
 	}
-	return err
+	return decodeError("ascii85", err)
 }
 
 func (e *Engine) base32() error {
@@ -58,7 +58,7 @@ func (e *Engine) unbase32() error {
 	if err == nil {
 		e.stack.Push(dec[0:n])
 	}
-	return err
+	return decodeError("base32", err)
 }
 
 func (e *Engine) base32_hex() error {
@@ -76,7 +76,7 @@ func (e *Engine) unbase32_hex() error {
 	if err == nil {
 		e.stack.Push(dec[0:n])
 	}
-	return err
+	return decodeError("base32-hex", err)
 }
 
 func (e *Engine) base64() error {
@@ -94,7 +94,7 @@ func (e *Engine) unbase64() error {
 	if err == nil {
 		e.stack.Push(dec[0:n])
 	}
-	return err
+	return decodeError("base64", err)
 }
 
 func (e *Engine) base64_url() error {
@@ -112,5 +112,5 @@ func (e *Engine) unbase64_url() error {
 	if err == nil {
 		e.stack.Push(dec[0:n])
 	}
-	return err
+	return decodeError("base64-url", err)
 }
@@ -2,9 +2,14 @@ package engine
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,26 +18,81 @@ const (
 
 // funcInfo stores information about the function definitions
 type funcInfo struct {
-	f    func() error
-	In   string
-	Out  string
-	Desc string
+	f        func(e *Engine) error
+	In       string
+	Out      string
+	Desc     string
+	Category string
+	Examples []Example
+}
+
+var (
+	// funcMap holds the built-in functions, shared by all engines
+	funcMap map[string]funcInfo
+
+	// defaultValues holds the variables every engine starts with
+	defaultValues map[string][]byte
+)
+
+func init() {
+	initMap()
+	initExamples()
+	initDefaults()
 }
 
 // The Engine is the processing logic of the hash server
 type Engine struct {
 	stack     *Stack
-	values    map[string][]byte
-	funcMap   map[string]funcInfo
+	values    map[string]Value // changes made over defaultValues
+	varSize   int              // the combined size of the values held in memory
+	readOnly  map[string]bool  // variables protected by SetReadOnlyVariable
+	secret    map[string]bool  // variables hidden from the help page and debug output
+	secrets   [][]byte         // other values redacted from the debug output
+	funcs     map[string]funcInfo
 	logBuf    *bytes.Buffer
 	DebugMode bool
+	Limits    Limits
+
+	// NoDefaultKey makes loading the key fail when it is still the default
+	// key, which is the same for every installation.
+	NoDefaultKey bool
+
+	// Logger receives the engine's debug messages. It uses slog.Default when nil.
+	Logger *slog.Logger
+
+	// Keyring holds the keys used by protect and unprotect.
+	Keyring *Keyring
+
+	// SpillBytes is the size above which values read by ReadValue, or made
+	// from values in temporary files, are kept in temporary files instead of
+	// memory. Zero keeps every value in memory.
+	SpillBytes int
+
+	// TempDir is the folder for the temporary files. It uses os.TempDir when
+	// empty.
+	TempDir string
+
+	// Tracer receives each step of a run. In debug mode, the run returns the
+	// HTML debug page, or the JSON document when Tracer is a JSONTracer.
+	Tracer  Tracer
+	trace   Tracer           // the tracer used by the current run
+	stats   *RunStats        // the profile of the current run, if wanted
+	pipes   []*io.PipeReader // the pipes of the current stream
+	piped   bool             // whether the stream is below the values on the stack
+	temps   []*os.File       // the temporary files holding values
+	spilled int              // the combined size of the temporary files
+
+	// state used to enforce the limits
+	ctx      context.Context
+	depth    int
+	ops      int
+	deadline time.Time
 }
 
 // New creates a new engine
 func New() *Engine {
 	e := new(Engine)
 	e.logBuf = new(bytes.Buffer)
-	e.initMap()
 	e.Reset()
 	return e
 }
@@ -40,14 +100,34 @@ func New() *Engine {
 // Reset returns the engine to the initial state
 func (e *Engine) Reset() {
 	e.stack = NewStack()
-	e.values = make(map[string][]byte)
+	// the defaults are shared, so only the engine's own changes are cleared
+	if e.values == nil {
+		e.values = make(map[string]Value)
+		e.readOnly = make(map[string]bool)
+		e.secret = make(map[string]bool)
+	} else {
+		clear(e.values)
+		clear(e.readOnly)
+		clear(e.secret)
+	}
+	e.varSize = 0
+	clear(e.secrets)
+	e.secrets = e.secrets[:0]
 	e.logBuf.Reset()
 	e.DebugMode = false
-	e.initVars()
+	e.Tracer = nil
+	e.Logger = nil
+	e.trace = nil
+	e.stats = nil
+	e.ctx = context.Background()
+	e.removeTemps()
 }
 
-// initVars sets the default variables
-func (e *Engine) initVars() {
+// initDefaults builds the default variables shared by every engine
+func initDefaults() {
+	defaultValues = make(map[string][]byte)
+	set := func(name string, value []byte) { defaultValues[name] = value }
+
 	var hmacFmt = func(alg string) []byte { return []byte(fmt.Sprintf("/key/load/%s/hmac-%s", alg, alg)) }
 
 	var encryptFmt = func(alg, hash string) []byte {
@@ -59,70 +139,93 @@ func (e *Engine) initVars() {
 
 	var signFmt = func(alg string) []byte { return []byte(fmt.Sprintf("/push/hash-hmac-%s/call/swap/append", alg)) }
 	var checksigFmt = func(alg string) []byte {
-		return []byte(fmt.Sprintf("/%s-len/snip/swap/temp/save/push/hash-hmac-%s/call/temp/load/eq", alg, alg))
+		return []byte(fmt.Sprintf("/%s-len/snip/swap/temp/save/push/hash-hmac-%s/call/temp/load/ct-eq", alg, alg))
 	}
 
 	// Add the default key
-	e.SetVariable("key", []byte(defaultKey))
+	set("key", []byte(defaultKey))
+
+	// Add a program that does nothing, for use with conditionals
+	set("nop", []byte(""))
 
 	// encrypt and sign the data
-	e.SetVariable("encrypt-twofish", encryptFmt("twofish", "sha256"))
-	e.SetVariable("decrypt-twofish", decryptFmt("twofish", "sha256"))
-	e.SetVariable("encrypt-blowfish", encryptFmt("blowfish", "sha256"))
-	e.SetVariable("decrypt-blowfish", decryptFmt("blowfish", "sha256"))
-	e.SetVariable("encrypt-aes", encryptFmt("aes", "md5"))
-	e.SetVariable("decrypt-aes", decryptFmt("aes", "md5"))
-	e.SetVariable("encrypt-des", encryptFmt("des", "crc64-iso"))
-	e.SetVariable("decrypt-des", decryptFmt("des", "crc64-iso"))
-	e.SetVariable("encrypt-3des", encryptFmt("3des", "push/md5/swap/crc64-iso/append"))
-	e.SetVariable("decrypt-3des", decryptFmt("3des", "push/md5/swap/crc64-iso/append"))
+	set("encrypt-twofish", encryptFmt("twofish", "sha256"))
+	set("decrypt-twofish", decryptFmt("twofish", "sha256"))
+	set("encrypt-blowfish", encryptFmt("blowfish", "sha256"))
+	set("decrypt-blowfish", decryptFmt("blowfish", "sha256"))
+	set("encrypt-aes", encryptFmt("aes", "md5"))
+	set("decrypt-aes", decryptFmt("aes", "md5"))
+	set("encrypt-des", encryptFmt("des", "crc64-iso"))
+	set("decrypt-des", decryptFmt("des", "crc64-iso"))
+	set("encrypt-3des", encryptFmt("3des", "push/md5/swap/crc64-iso/append"))
+	set("decrypt-3des", decryptFmt("3des", "push/md5/swap/crc64-iso/append"))
 
 	// Add shortcuts that use the value stored in "key" to do an HMAC
-	e.SetVariable("hash-hmac-md5", hmacFmt("md5"))
-	e.SetVariable("hash-hmac-sha1", hmacFmt("sha1"))
-	e.SetVariable("hash-hmac-sha224", hmacFmt("sha224"))
-	e.SetVariable("hash-hmac-sha256", hmacFmt("sha256"))
-	e.SetVariable("hash-hmac-sha384", hmacFmt("sha384"))
-	e.SetVariable("hash-hmac-sha512", hmacFmt("sha512"))
-	e.SetVariable("hash-hmac-ripemd160", hmacFmt("ripemd160"))
+	set("hash-hmac-md5", hmacFmt("md5"))
+	set("hash-hmac-sha1", hmacFmt("sha1"))
+	set("hash-hmac-sha224", hmacFmt("sha224"))
+	set("hash-hmac-sha256", hmacFmt("sha256"))
+	set("hash-hmac-sha384", hmacFmt("sha384"))
+	set("hash-hmac-sha512", hmacFmt("sha512"))
+	set("hash-hmac-ripemd160", hmacFmt("ripemd160"))
+	set("hash-hmac-sha3-224", hmacFmt("sha3-224"))
+	set("hash-hmac-sha3-256", hmacFmt("sha3-256"))
+	set("hash-hmac-sha3-384", hmacFmt("sha3-384"))
+	set("hash-hmac-sha3-512", hmacFmt("sha3-512"))
+	set("hash-hmac-sha512-224", hmacFmt("sha512-224"))
+	set("hash-hmac-sha512-256", hmacFmt("sha512-256"))
+	set("hash-hmac-blake2b-256", hmacFmt("blake2b-256"))
+	set("hash-hmac-blake2b-384", hmacFmt("blake2b-384"))
+	set("hash-hmac-blake2b-512", hmacFmt("blake2b-512"))
+	set("hash-hmac-blake2s-256", hmacFmt("blake2s-256"))
 
 	// sign and check signature
-	e.SetVariable("sign-md5", signFmt("md5"))
-	e.SetVariable("checksig-md5", checksigFmt("md5"))
-	e.SetVariable("sign-sha1", signFmt("sha1"))
-	e.SetVariable("checksig-sha1", checksigFmt("sha1"))
-	e.SetVariable("sign-sha224", signFmt("sha224"))
-	e.SetVariable("checksig-sha224", checksigFmt("sha224"))
-	e.SetVariable("sign-sha256", signFmt("sha256"))
-	e.SetVariable("checksig-sha256", checksigFmt("sha256"))
-	e.SetVariable("sign-sha384", signFmt("sha384"))
-	e.SetVariable("checksig-sha384", checksigFmt("sha384"))
-	e.SetVariable("sign-sha512", signFmt("sha512"))
-	e.SetVariable("checksig-sha512", checksigFmt("sha512"))
-	e.SetVariable("sign-ripemd160", signFmt("ripemd160"))
-	e.SetVariable("checksig-ripemd160", checksigFmt("ripemd160"))
+	set("sign-md5", signFmt("md5"))
+	set("checksig-md5", checksigFmt("md5"))
+	set("sign-sha1", signFmt("sha1"))
+	set("checksig-sha1", checksigFmt("sha1"))
+	set("sign-sha224", signFmt("sha224"))
+	set("checksig-sha224", checksigFmt("sha224"))
+	set("sign-sha256", signFmt("sha256"))
+	set("checksig-sha256", checksigFmt("sha256"))
+	set("sign-sha384", signFmt("sha384"))
+	set("checksig-sha384", checksigFmt("sha384"))
+	set("sign-sha512", signFmt("sha512"))
+	set("checksig-sha512", checksigFmt("sha512"))
+	set("sign-ripemd160", signFmt("ripemd160"))
+	set("checksig-ripemd160", checksigFmt("ripemd160"))
+	set("sign-sha3-224", signFmt("sha3-224"))
+	set("checksig-sha3-224", checksigFmt("sha3-224"))
+	set("sign-sha3-256", signFmt("sha3-256"))
+	set("checksig-sha3-256", checksigFmt("sha3-256"))
+	set("sign-sha3-384", signFmt("sha3-384"))
+	set("checksig-sha3-384", checksigFmt("sha3-384"))
+	set("sign-sha3-512", signFmt("sha3-512"))
+	set("checksig-sha3-512", checksigFmt("sha3-512"))
+	set("sign-sha512-224", signFmt("sha512-224"))
+	set("checksig-sha512-224", checksigFmt("sha512-224"))
+	set("sign-sha512-256", signFmt("sha512-256"))
+	set("checksig-sha512-256", checksigFmt("sha512-256"))
+	set("sign-blake2b-256", signFmt("blake2b-256"))
+	set("checksig-blake2b-256", checksigFmt("blake2b-256"))
+	set("sign-blake2b-384", signFmt("blake2b-384"))
+	set("checksig-blake2b-384", checksigFmt("blake2b-384"))
+	set("sign-blake2b-512", signFmt("blake2b-512"))
+	set("checksig-blake2b-512", checksigFmt("blake2b-512"))
+	set("sign-blake2s-256", signFmt("blake2s-256"))
+	set("checksig-blake2s-256", checksigFmt("blake2s-256"))
 
 	// encrypt and sign
-	e.SetVariable("encrypt-sign-twofish", []byte("/encrypt-twofish/call/sign-sha256/call"))
-	e.SetVariable("decrypt-sign-twofish", []byte("/checksig-sha256/call/decrypt-twofish/call"))
-	e.SetVariable("encrypt-sign-blowfish", []byte("/encrypt-blowfish/call/sign-sha256/call"))
-	e.SetVariable("decrypt-sign-blowfish", []byte("/checksig-sha256/call/decrypt-blowfish/call"))
-	e.SetVariable("encrypt-sign-aes", []byte("/encrypt-aes/call/sign-sha256/call"))
-	e.SetVariable("decrypt-sign-aes", []byte("/checksig-sha256/call/decrypt-aes/call"))
-	e.SetVariable("encrypt-sign-des", []byte("/encrypt-des/call/sign-sha256/call"))
-	e.SetVariable("decrypt-sign-des", []byte("/checksig-sha256/call/decrypt-des/call"))
-	e.SetVariable("encrypt-sign-3des", []byte("/encrypt-3des/call/sign-sha256/call"))
-	e.SetVariable("decrypt-sign-3des", []byte("/checksig-sha256/call/decrypt-3des/call"))
-}
-
-// SetVariable sets a variable for the engine to use
-func (e *Engine) SetVariable(name string, value []byte) {
-	e.values[strings.ToLower(name)] = value
-}
-
-// GetVariable returns the value of a variable in the engine
-func (e *Engine) GetVariable(name string) []byte {
-	return e.values[strings.ToLower(name)]
+	set("encrypt-sign-twofish", []byte("/encrypt-twofish/call/sign-sha256/call"))
+	set("decrypt-sign-twofish", []byte("/checksig-sha256/call/decrypt-twofish/call"))
+	set("encrypt-sign-blowfish", []byte("/encrypt-blowfish/call/sign-sha256/call"))
+	set("decrypt-sign-blowfish", []byte("/checksig-sha256/call/decrypt-blowfish/call"))
+	set("encrypt-sign-aes", []byte("/encrypt-aes/call/sign-sha256/call"))
+	set("decrypt-sign-aes", []byte("/checksig-sha256/call/decrypt-aes/call"))
+	set("encrypt-sign-des", []byte("/encrypt-des/call/sign-sha256/call"))
+	set("decrypt-sign-des", []byte("/checksig-sha256/call/decrypt-des/call"))
+	set("encrypt-sign-3des", []byte("/encrypt-3des/call/sign-sha256/call"))
+	set("decrypt-sign-3des", []byte("/checksig-sha256/call/decrypt-3des/call"))
 }
 
 // PushStack is used to intialize the stack of the engine. It is typically
@@ -133,195 +236,495 @@ func (e *Engine) PushStack(value []byte) {
 
 // Run executes the logic of the engine, returning the last value from the stack.
 func (e *Engine) Run(commands []string) ([]byte, error) {
-	var err error
+	return e.RunContext(context.Background(), commands)
+}
 
-	if e.DebugMode {
-		e.logBuf.Write([]byte(htmlHeader))
+// RunContext is like Run, but stops early with the context's error if the
+// context is cancelled.
+func (e *Engine) RunContext(ctx context.Context, commands []string) ([]byte, error) {
+	p, err := Compile(commands)
+	if err != nil {
+		rep := e.startTrace()
+		defer func() { e.trace = nil }()
+		if rep == nil {
+			return nil, err
+		}
+		// show the error on the debug page
+		e.Log(err)
+		return rep.report(nil, err, nil), nil
 	}
+	return e.RunProgramContext(ctx, p)
+}
 
-	//e.LogValues()
+// RunProgram executes a compiled program, returning the last value from the stack.
+// The same program may be run by many engines.
+func (e *Engine) RunProgram(p *Program) ([]byte, error) {
+	return e.RunProgramContext(context.Background(), p)
+}
 
-	err = e.exec(commands)
+// RunProgramContext is like RunProgram, but stops early with the context's
+// error if the context is cancelled.
+func (e *Engine) RunProgramContext(ctx context.Context, p *Program) ([]byte, error) {
+	v, err := e.RunValue(ctx, p)
 	if err != nil {
-		if e.DebugMode {
-			e.Log(err)
-		} else {
-			return nil, err
+		return nil, err
+	}
+	return valueBytes(v)
+}
+
+// RunValue is like RunProgramContext, but returns the result without
+// loading it into memory when it is kept in a temporary file. The result is
+// valid until the engine is Reset.
+func (e *Engine) RunValue(ctx context.Context, p *Program) (Value, error) {
+	var err, firstErr error
+
+	e.ctx = ctx
+	rep := e.startTrace()
+	if rep != nil && e.stats == nil {
+		// debug mode shows a profile of the run
+		e.stats = new(RunStats)
+		defer func() { e.stats = nil }()
+	}
+	defer func() {
+		e.ctx = context.Background()
+		e.trace = nil
+	}()
+	start := time.Now()
+	finished := false
+	finish := func() {
+		if e.stats != nil && !finished {
+			e.stats.finish(time.Since(start))
+			finished = true
+		}
+	}
+	// the profile is also wanted when the run fails
+	defer finish()
+
+	// in debug mode, errors are shown in the output instead of returned
+	fail := func(err error) error {
+		if rep == nil {
+			return err
+		}
+		e.Log(err)
+		if firstErr == nil {
+			firstErr = err
 		}
+		return nil
 	}
 
+	//e.LogValues()
 	e.LogStack()
 
-	b := e.stack.Pop()
+	e.startLimits()
+	err = p.check(e)
+	if err == nil {
+		err = e.execProgram(p)
+	}
+	if err != nil {
+		if err = fail(err); err != nil {
+			return nil, err
+		}
+	}
+
+	b := e.stack.PopValue()
 	if b == nil {
-		err = errors.New("nothing left on the stack to return")
-		if e.DebugMode {
-			e.Log(err)
-		} else {
+		if err = fail(&StackUnderflowError{Op: p.String(), Need: 1}); err != nil {
 			return nil, err
 		}
 	}
 
 	if e.stack.Len() > 0 {
-		err = fmt.Errorf("%d unused items on stack", e.stack.Len())
-		if e.DebugMode {
-			e.Log(err)
-		} else {
+		if err = fail(&UnusedValuesError{Op: p.String(), Left: e.stack.Len() + 1}); err != nil {
 			return nil, err
 		}
 	}
 
-	if e.DebugMode {
-		e.logBuf.Write([]byte(htmlFooter))
-		b = e.logBuf.Bytes()
+	finish()
+
+	if rep != nil {
+		var result *TraceValue
+		if b != nil {
+			v := e.stackValue(b)
+			result = &v
+		}
+		b = bytesValue(rep.report(result, firstErr, e.stats))
 	}
 
 	return b, nil
 }
 
-// exec runs the commands for an already initialized engine
-func (e *Engine) exec(commands []string) error {
-	var err error
-	e.Log("exec /", strings.Join(commands, "/"))
-	for _, s := range commands {
-		e.LogStack()
-		fd, ok := e.funcMap[strings.TrimSpace(s)]
-		if ok {
-			e.Logf("(%s) -> %s -> (%s)", fd.In, s, fd.Out)
-			err = fd.f()
-		} else {
-			e.Logf("push %+q", s)
-			e.stack.Push([]byte(s))
+// execProgram runs a compiled program for an already initialized engine
+func (e *Engine) execProgram(p *Program) error {
+	e.depth++
+	defer func() { e.depth-- }()
+	if e.Limits.MaxCallDepth > 0 && e.depth > e.Limits.MaxCallDepth {
+		return &LimitExceededError{Err: ErrCallDepth, Limit: int64(e.Limits.MaxCallDepth)}
+	}
+
+	e.Log("exec ", p)
+	debug := e.debugEnabled()
+	for i := range p.ops {
+		o := &p.ops[i]
+
+		// capture the state for the log, the tracer, and the profile
+		measure := debug || e.trace != nil || e.stats != nil
+		var step *Step
+		var vars map[string]Value
+		var before []Value
+		var secret bool
+		var start time.Time
+		idx := -1
+		if measure {
+			if e.trace != nil {
+				step = e.beginStep(o)
+				vars = maps.Clone(e.values)
+			}
+			if e.stats != nil {
+				idx = len(e.stats.Ops)
+				e.stats.Ops = append(e.stats.Ops, OpStats{Op: o.name, Depth: e.depth})
+			}
+			before = e.stack.Values()
+			secret = e.trace != nil && e.takesSecret(o, before)
+			start = time.Now()
+		}
+
+		n := e.stack.Len()
+		err := e.checkOp()
+		if err == nil {
+			err = e.execOp(o)
+		}
+		if err == nil {
+			err = e.checkStack()
+		}
+
+		if measure {
+			d := time.Since(start)
+			after := e.stack.Values()
+			in, out := stackDelta(before, after)
+			if secret {
+				// values made from secrets are secret too
+				e.addSecrets(before, after)
+			}
+			if debug {
+				e.logger().LogAttrs(e.ctx, slog.LevelDebug, "op", slog.String("op", o.name), slog.Int("depth", e.depth),
+					slog.Duration("duration", d), slog.Int64("bytesIn", in), slog.Int64("bytesOut", out), slog.Int("stack", e.stack.Len()))
+			}
+			if idx >= 0 {
+				st := &e.stats.Ops[idx]
+				st.Duration, st.BytesIn, st.BytesOut = d, in, out
+			}
+			if step != nil {
+				step.Duration, step.BytesIn, step.BytesOut = d, in, out
+				e.endStep(step, vars, err)
+			}
 		}
 		if err != nil {
-			return err
+			return &OpError{Op: o.name, Index: i, StackDepth: n, Err: err}
 		}
 	}
 	e.Log("end")
 	return nil
 }
 
-func (e *Engine) initMap() {
-	e.funcMap = map[string]funcInfo{
-		// hashing
-		"md5":       {f: e.md5, In: "Data", Out: "Hash", Desc: "Hashes data using MD5"},
-		"sha1":      {f: e.sha1, In: "Data", Out: "Hash", Desc: "Hashes data using SHA1"},
-		"sha224":    {f: e.sha224, In: "Data", Out: "Hash", Desc: "Hashes data using SHA224"},
-		"sha256":    {f: e.sha256, In: "Data", Out: "Hash", Desc: "Hashes data using SHA256"},
-		"sha384":    {f: e.sha384, In: "Data", Out: "Hash", Desc: "Hashes data using SHA384"},
-		"sha512":    {f: e.sha512, In: "Data", Out: "Hash", Desc: "Hashes data using SHA512"},
-		"ripemd160": {f: e.ripemd160, In: "Data", Out: "Hash", Desc: "Hashes data using RIPEMD160"},
-		"rand":      {f: e.rand, In: "Count", Out: "Data", Desc: "Generates cryptographically random bytes given the count on the stack"},
-
-		"md5-len":       {f: e.md5_len, In: "", Out: "16", Desc: "Returns the number of bytes for MD5"},
-		"sha1-len":      {f: e.sha1_len, In: "", Out: "20", Desc: "Returns the number of bytes for  SHA1"},
-		"sha224-len":    {f: e.sha224_len, In: "", Out: "28", Desc: "Returns the number of bytes for  SHA224"},
-		"sha256-len":    {f: e.sha256_len, In: "", Out: "32", Desc: "Returns the number of bytes for  SHA256"},
-		"sha384-len":    {f: e.sha384_len, In: "", Out: "48", Desc: "Returns the number of bytes for  SHA384"},
-		"sha512-len":    {f: e.sha512_len, In: "", Out: "64", Desc: "Returns the number of bytes for  SHA512"},
-		"ripemd160-len": {f: e.ripemd160_len, In: "", Out: "20", Desc: "Returns the number of bytes for  RIPEMD160"},
-
-		// HMAC hashing
-		"hmac-md5":       {f: e.hmac_md5, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using MD5"},
-		"hmac-sha1":      {f: e.hmac_sha1, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA1"},
-		"hmac-sha224":    {f: e.hmac_sha224, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA224"},
-		"hmac-sha256":    {f: e.hmac_sha256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA256"},
-		"hmac-sha384":    {f: e.hmac_sha384, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA384"},
-		"hmac-sha512":    {f: e.hmac_sha512, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA512"},
-		"hmac-ripemd160": {f: e.hmac_ripemd160, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using RIPEMD160"},
-
-		// encoding
-		"hex":          {f: e.hex, In: "Data", Out: "EncodedData", Desc: "Encode the data to hex"},
-		"unhex":        {f: e.unhex, In: "EncodedData", Out: "Data", Desc: "Decode the data from hex"},
-		"ascii85":      {f: e.ascii85, In: "Data", Out: "EncodedData", Desc: "Encode the data to ascii-85"},
-		"unascii85":    {f: e.unascii85, In: "EncodedData", Out: "Data", Desc: "Decode the data from ascii-85"},
-		"base32":       {f: e.base32, In: "Data", Out: "EncodedData", Desc: "Encode the data to base32"},
-		"unbase32":     {f: e.unbase32, In: "EncodedData", Out: "Data", Desc: "Decode the data from base32"},
-		"base32-hex":   {f: e.base32_hex, In: "Data", Out: "EncodedData", Desc: "Encode the data to base32 hex"},
-		"unbase32-hex": {f: e.unbase32_hex, In: "EncodedData", Out: "Data", Desc: "Decode the data from base32 hex"},
-		"base64":       {f: e.base64, In: "Data", Out: "EncodedData", Desc: "Encode the data to base64"},
-		"unbase64":     {f: e.unbase64, In: "EncodedData", Out: "Data", Desc: "Decode the data from base64"},
-		"base64-url":   {f: e.base64_url, In: "Data", Out: "EncodedData", Desc: "Encode the data to base64 url"},
-		"unbase64-url": {f: e.unbase64_url, In: "EncodedData", Out: "Data", Desc: "Decode the data from base64 url"},
-
-		// checksum hashing
-		"adler32":          {f: e.adler32, In: "Data", Out: "Checksum", Desc: "Compute the Adler-32 checksum"},
-		"crc32":            {f: e.crc32, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the IEEE polynomial"},
-		"crc32-ieee":       {f: e.crc32_ieee, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the IEEE polynomial"},
-		"crc32-castagnoli": {f: e.crc32_castagnoli, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the Castagnoli polynomial"},
-		"crc32-koopman":    {f: e.crc32_koopman, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the Koopman polynomial"},
-		"crc64-iso":        {f: e.crc64_iso, In: "Data", Out: "Checksum", Desc: "Compute the CRC-64 checksum using the ISO polynomial"},
-		"crc64-ecma":       {f: e.crc64_ecma, In: "Data", Out: "Checksum", Desc: "Compute the CRC-64 checksum using the ECMA polynomial"},
-		"fnv32":            {f: e.fnv32, In: "Data", Out: "Hash", Desc: "Compute the FNV-1 non-cryptographic hash for 32-bits"},
-		"fnv32a":           {f: e.fnv32a, In: "Data", Out: "Hash", Desc: "Compute the FNV-1a non-cryptographic hash for 32-bits"},
-		"fnv64":            {f: e.fnv64, In: "Data", Out: "Hash", Desc: "Compute the FNV-1 non-cryptographic hash for 64-bits"},
-		"fnv64a":           {f: e.fnv64a, In: "Data", Out: "Hash", Desc: "Compute the FNV-1a non-cryptographic hash for 64-bits"},
-
-		// Compression
-		"snappy":    {f: e.snappy, In: "Data", Out: "Compressed", Desc: "Compresses data using the Snappy algorithm"},
-		"unsnappy":  {f: e.unsnappy, In: "Compressed", Out: "Data", Desc: "Decompresses data using the Snappy algorithm"},
-		"zlib":      {f: e.zlib, In: "Data", Out: "Compressed", Desc: "Compresses data using the zlib algorithm"},
-		"unzlib":    {f: e.unzlib, In: "Compressed", Out: "Data", Desc: "Decompresses data using the zlib algorithm"},
-		"deflate":   {f: e.deflate, In: "Data, Factor", Out: "Compressed", Desc: "Compresses data using the flate algorithm - stack contains a compression factor where -1 is default and 0-9 controls compression (0 is none, and 9 is the most)"},
-		"inflate":   {f: e.inflate, In: "Compressed", Out: "Data", Desc: "Decompresses data using the flate algorithm"},
-		"gzip":      {f: e.gzip, In: "Data, Factor", Out: "Compressed", Desc: "Compresses data using the gzip algorithm - stack contains a compression factor where -1 is default, 0 is none, 1 is best speed, and 9 is best size"},
-		"ungzip":    {f: e.ungzip, In: "Compressed", Out: "Data", Desc: "Decompresses data using the gzip algorithm"},
-		"unbzip2":   {f: e.unbzip2, In: "Compressed", Out: "Data", Desc: "Decompresses data using the bzip2 algorithm"},
-		"lzw-msb":   {f: e.lzw_msb, In: "Data, Bits", Out: "Compressed", Desc: "Compresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses most significant bit ordering as used in the TIFF and PDF file formats."},
-		"lzw-lsb":   {f: e.lzw_lsb, In: "Data, Bits", Out: "Compressed", Desc: "Compresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses least significant bit ordering as used in the GIF file format."},
-		"unlzw-msb": {f: e.unlzw_msb, In: "Compressed, Bits", Out: "Data", Desc: "Decompresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses most significant bit ordering as used in the TIFF and PDF file formats."},
-		"unlzw-lsb": {f: e.unlzw_lsb, In: "Compressed, Bits", Out: "Data", Desc: "Decompresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses least significant bit ordering as used in the GIF file format."},
-
-		// control commands
-		"push":   {f: e.push, In: "Data", Out: "Data, Data", Desc: "Duplicates the value on the top of the stack"},
-		"pop":    {f: e.pop, In: "Data", Out: "", Desc: "Pops the value off the top of the stack (effectively discarding)"},
-		"load":   {f: e.load, In: "Name", Out: "Value", Desc: "Pushes a named value from the dictinary onto the stack"},
-		"save":   {f: e.save, In: "Value, Name", Out: "", Desc: "Pops a value from the stack and places it into the dictionary"},
-		"swap":   {f: e.swap, In: "Val1, Val2", Out: "Val2, Val1", Desc: "Swaps the two values at the top of the stack"},
-		"append": {f: e.append, In: "Val1, Val2", Out: "Appended", Desc: "Appends the value on the top of the stack to the previous value on the stack"},
-		"slice":  {f: e.slice, In: "Data, Start, End", Out: "SliceOfData", Desc: "Slices the value on the stack, taking elements from start to end on the stack. Use -1 for values from the beginning or end. One example is /9/20/slice which takes elements 9 through 19, or /2/-1/slice which takes elements 2 through the end."},
-		"len":    {f: e.len, In: "Data", Out: "Data, Length", Desc: "Pushes the length of the value on the stack in bytes onto the stack"},
-		"left":   {f: e.left, In: "Data, Count", Out: "SliceOfData", Desc: "Takes the leftmost bytes of data"},
-		"right":  {f: e.right, In: "Data, Count", Out: "SliceOfData", Desc: "Takes the rightmost bytes of data"},
-		"snip":   {f: e.snip, In: "Data, Position", Out: "Data1, Data2", Desc: "Snips the data in half at the given position, resulting in two values on the stack"},
-		"eq":     {f: e.eq, In: "Data1, Data2", Out: "", Desc: "Fails the command unless the two data elements are equal"},
-		"neq":    {f: e.neq, In: "Data1, Data2", Out: "", Desc: "Fails the command unless the two data elements are not equal"},
-		"call":   {f: e.call, In: "Name", Out: "(varies)", Desc: "Loads the named value from the dictionary and executes the commands contained there (formatted like normal - /md5/hex for example)"},
-
-		// Crypto
-		"aes-cfb":       {f: e.aes_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 16-byte Key, placing the ciphertext back on the stack. Uses AES encryption and the CFB block mode."},
-		"unaes-cfb":     {f: e.unaes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 16-byte Key, placing the plaintext back on the stack. Uses AES encryption and the CFB block mode."},
-		"aes-ofb":       {f: e.aes_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16-byte Key, placing the result back on the stack. Uses AES encryption and the OFB block mode."},
-		"aes-ctr":       {f: e.aes_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16-byte Key, placing the result back on the stack. Uses AES encryption and the CTR block mode."},
-		"aes-blocksize": {f: e.aes_blocksize, In: "", Out: "16", Desc: "Pushes the AES block size on the stack"},
-
-		"des-cfb":   {f: e.des_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 8-byte Key, placing the ciphertext back on the stack. Uses DES encryption and the CFB block mode."},
-		"undes-cfb": {f: e.undes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 8-byte Key, placing the plaintext back on the stack. Uses DES encryption and the CFB block mode."},
-		"des-ofb":   {f: e.des_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 8-byte Key, placing the result back on the stack. Uses DES encryption and the OFB block mode."},
-		"des-ctr":   {f: e.des_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 8-byte Key, placing the result back on the stack. Uses DES encryption and the CTR block mode."},
-
-		"3des-cfb":       {f: e.tripledes_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 24-byte Key, placing the ciphertext back on the stack. Uses Triple DES encryption and the CFB block mode."},
-		"un3des-cfb":     {f: e.untripledes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 24-byte Key, placing the plaintext back on the stack. Uses Triple DES encryption and the CFB block mode."},
-		"3des-ofb":       {f: e.tripledes_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 24-byte Key, placing the result back on the stack. Uses Triple DES encryption and the OFB block mode."},
-		"3des-ctr":       {f: e.tripledes_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 24-byte Key, placing the result back on the stack. Uses Triple DES encryption and the CTR block mode."},
-		"des-blocksize":  {f: e.des_blocksize, In: "", Out: "8", Desc: "Pushes the DES block size on the stack"},
-		"3des-blocksize": {f: e.des_blocksize, In: "", Out: "8", Desc: "Pushes the Triple DES block size on the stack"},
-
-		"blowfish-cfb":       {f: e.blowfish_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 1 to 56-byte Key, placing the ciphertext back on the stack. Uses Blowfish encryption and the CFB block mode."},
-		"unblowfish-cfb":     {f: e.unblowfish_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 1 to 56-byte Key, placing the plaintext back on the stack. Uses Blowfish encryption and the CFB block mode."},
-		"blowfish-ofb":       {f: e.blowfish_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the OFB block mode."},
-		"blowfish-ctr":       {f: e.blowfish_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the CTR block mode."},
-		"blowfish-blocksize": {f: e.blowfish_blocksize, In: "", Out: "8", Desc: "Pushes the blowfish block size on the stack"},
-
-		"blowfish-salt-cfb":   {f: e.blowfish_salt_cfb, In: "PlainData, IV, Key, Salt", Out: "CipherData", Desc: "Encrypts data using the given IV and 1 to 56-byte Key, placing the ciphertext back on the stack. Uses Blowfish encryption and the CFB block mode."},
-		"unblowfish-salt-cfb": {f: e.unblowfish_salt_cfb, In: "CipherData, IV, Key, Salt", Out: "PlainData", Desc: "Decrypts data using the given IV and 1 to 56-byte Key, placing the plaintext back on the stack. Uses Blowfish encryption and the CFB block mode."},
-		"blowfish-salt-ofb":   {f: e.blowfish_salt_ofb, In: "Data, IV, Key, Salt", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the OFB block mode."},
-		"blowfish-salt-ctr":   {f: e.blowfish_salt_ctr, In: "Data, IV, Key, Salt", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the CTR block mode."},
-
-		"twofish-cfb":       {f: e.twofish_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 16, 24, or 32-byte Key, placing the ciphertext back on the stack. Uses Twofish encryption and the CFB block mode."},
-		"untwofish-cfb":     {f: e.untwofish_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 16, 24, or 32-byte Key, placing the plaintext back on the stack. Uses Twofish encryption and the CFB block mode."},
-		"twofish-ofb":       {f: e.twofish_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16, 24, or 32-byte Key, placing the result back on the stack. Uses Twofish encryption and the OFB block mode."},
-		"twofish-ctr":       {f: e.twofish_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16, 24, or 32-byte Key, placing the result back on the stack. Uses Twofish encryption and the CTR block mode."},
-		"twofish-blocksize": {f: e.twofish_blocksize, In: "", Out: "16", Desc: "Pushes the twofish block size on the stack"},
+// execOp runs a single operation of a compiled program
+func (e *Engine) execOp(o *op) error {
+	switch {
+	case o.fd != nil:
+		e.Logf("(%s) -> %s -> (%s)", o.fd.In, o.name, o.fd.Out)
+		if n := e.stack.Len(); n < o.in {
+			return &StackUnderflowError{Op: o.name, Need: o.in, Have: n}
+		}
+		if valueFuncs[strings.TrimSpace(o.name)] {
+			return e.runFunc(o.fd.f)
+		}
+		if done, err := e.execFileOp(o); done {
+			return err
+		}
+		if err := e.loadValues(o.in); err != nil {
+			return err
+		}
+		return e.runFunc(o.fd.f)
+	case o.macro != nil:
+		// only use the expanded macro if it was not replaced
+		if e.valueIs(o.name, o.src) {
+			e.Logf("call %s", o.name)
+			return e.execProgram(o.macro)
+		}
+		return e.callNamed(o.name)
+	}
+	if fd, ok := e.funcs[strings.TrimSpace(o.name)]; o.word && ok {
+		e.Logf("(%s) -> %s -> (%s)", fd.In, o.name, fd.Out)
+		in, n := countValues(fd.In), e.stack.Len()
+		if n < in {
+			return &StackUnderflowError{Op: o.name, Need: in, Have: n}
+		}
+		if err := e.loadValues(in); err != nil {
+			return err
+		}
+		return e.runFunc(fd.f)
+	}
+	e.Logf("push %+q", o.lit)
+	e.stack.Push(o.lit)
+	return nil
+}
+
+// runFunc calls a function. An error reading a value in a temporary file is
+// returned instead of the function's own error, which may come from taking
+// the unreadable value for a missing one.
+func (e *Engine) runFunc(f func(e *Engine) error) error {
+	err := f(e)
+	if rerr := e.stack.takeErr(); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func initMap() {
+	funcMap = make(map[string]funcInfo)
+	add := func(category string, m map[string]funcInfo) {
+		for k, v := range m {
+			v.Category = category
+			funcMap[k] = v
+		}
 	}
+
+	// hashing
+	add("Hash", map[string]funcInfo{
+		"md5":       {f: (*Engine).md5, In: "Data", Out: "Hash", Desc: "Hashes data using MD5"},
+		"sha1":      {f: (*Engine).sha1, In: "Data", Out: "Hash", Desc: "Hashes data using SHA1"},
+		"sha224":    {f: (*Engine).sha224, In: "Data", Out: "Hash", Desc: "Hashes data using SHA224"},
+		"sha256":    {f: (*Engine).sha256, In: "Data", Out: "Hash", Desc: "Hashes data using SHA256"},
+		"sha384":    {f: (*Engine).sha384, In: "Data", Out: "Hash", Desc: "Hashes data using SHA384"},
+		"sha512":    {f: (*Engine).sha512, In: "Data", Out: "Hash", Desc: "Hashes data using SHA512"},
+		"ripemd160": {f: (*Engine).ripemd160, In: "Data", Out: "Hash", Desc: "Hashes data using RIPEMD160"},
+
+		"sha3-224":    {f: (*Engine).sha3_224, In: "Data", Out: "Hash", Desc: "Hashes data using SHA3-224"},
+		"sha3-256":    {f: (*Engine).sha3_256, In: "Data", Out: "Hash", Desc: "Hashes data using SHA3-256"},
+		"sha3-384":    {f: (*Engine).sha3_384, In: "Data", Out: "Hash", Desc: "Hashes data using SHA3-384"},
+		"sha3-512":    {f: (*Engine).sha3_512, In: "Data", Out: "Hash", Desc: "Hashes data using SHA3-512"},
+		"sha512-224":  {f: (*Engine).sha512_224, In: "Data", Out: "Hash", Desc: "Hashes data using SHA512/224"},
+		"sha512-256":  {f: (*Engine).sha512_256, In: "Data", Out: "Hash", Desc: "Hashes data using SHA512/256"},
+		"blake2b-256": {f: (*Engine).blake2b_256, In: "Data", Out: "Hash", Desc: "Hashes data using BLAKE2b with a 32-byte result"},
+		"blake2b-384": {f: (*Engine).blake2b_384, In: "Data", Out: "Hash", Desc: "Hashes data using BLAKE2b with a 48-byte result"},
+		"blake2b-512": {f: (*Engine).blake2b_512, In: "Data", Out: "Hash", Desc: "Hashes data using BLAKE2b with a 64-byte result"},
+		"blake2s-256": {f: (*Engine).blake2s_256, In: "Data", Out: "Hash", Desc: "Hashes data using BLAKE2s with a 32-byte result"},
+		"shake128":    {f: (*Engine).shake128, In: "Data, Length", Out: "Hash", Desc: "Hashes data using SHAKE128, producing the number of bytes given by the length"},
+		"shake256":    {f: (*Engine).shake256, In: "Data, Length", Out: "Hash", Desc: "Hashes data using SHAKE256, producing the number of bytes given by the length"},
+
+		"rand": {f: (*Engine).rand, In: "Count", Out: "Data", Desc: "Generates cryptographically random bytes given the count on the stack"},
+
+		"md5-len":       {f: (*Engine).md5_len, In: "", Out: "16", Desc: "Returns the number of bytes for MD5"},
+		"sha1-len":      {f: (*Engine).sha1_len, In: "", Out: "20", Desc: "Returns the number of bytes for  SHA1"},
+		"sha224-len":    {f: (*Engine).sha224_len, In: "", Out: "28", Desc: "Returns the number of bytes for  SHA224"},
+		"sha256-len":    {f: (*Engine).sha256_len, In: "", Out: "32", Desc: "Returns the number of bytes for  SHA256"},
+		"sha384-len":    {f: (*Engine).sha384_len, In: "", Out: "48", Desc: "Returns the number of bytes for  SHA384"},
+		"sha512-len":    {f: (*Engine).sha512_len, In: "", Out: "64", Desc: "Returns the number of bytes for  SHA512"},
+		"ripemd160-len": {f: (*Engine).ripemd160_len, In: "", Out: "20", Desc: "Returns the number of bytes for  RIPEMD160"},
+
+		"sha3-224-len":    {f: (*Engine).sha3_224_len, In: "", Out: "28", Desc: "Returns the number of bytes for SHA3-224"},
+		"sha3-256-len":    {f: (*Engine).sha3_256_len, In: "", Out: "32", Desc: "Returns the number of bytes for SHA3-256"},
+		"sha3-384-len":    {f: (*Engine).sha3_384_len, In: "", Out: "48", Desc: "Returns the number of bytes for SHA3-384"},
+		"sha3-512-len":    {f: (*Engine).sha3_512_len, In: "", Out: "64", Desc: "Returns the number of bytes for SHA3-512"},
+		"sha512-224-len":  {f: (*Engine).sha512_224_len, In: "", Out: "28", Desc: "Returns the number of bytes for SHA512/224"},
+		"sha512-256-len":  {f: (*Engine).sha512_256_len, In: "", Out: "32", Desc: "Returns the number of bytes for SHA512/256"},
+		"blake2b-256-len": {f: (*Engine).blake2b_256_len, In: "", Out: "32", Desc: "Returns the number of bytes for BLAKE2b-256"},
+		"blake2b-384-len": {f: (*Engine).blake2b_384_len, In: "", Out: "48", Desc: "Returns the number of bytes for BLAKE2b-384"},
+		"blake2b-512-len": {f: (*Engine).blake2b_512_len, In: "", Out: "64", Desc: "Returns the number of bytes for BLAKE2b-512"},
+		"blake2s-256-len": {f: (*Engine).blake2s_256_len, In: "", Out: "32", Desc: "Returns the number of bytes for BLAKE2s-256"},
+	})
+
+	// HMAC hashing
+	add("HMAC", map[string]funcInfo{
+		"hmac-md5":       {f: (*Engine).hmac_md5, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using MD5"},
+		"hmac-sha1":      {f: (*Engine).hmac_sha1, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA1"},
+		"hmac-sha224":    {f: (*Engine).hmac_sha224, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA224"},
+		"hmac-sha256":    {f: (*Engine).hmac_sha256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA256"},
+		"hmac-sha384":    {f: (*Engine).hmac_sha384, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA384"},
+		"hmac-sha512":    {f: (*Engine).hmac_sha512, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA512"},
+		"hmac-ripemd160": {f: (*Engine).hmac_ripemd160, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using RIPEMD160"},
+
+		"hmac-sha3-224":    {f: (*Engine).hmac_sha3_224, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA3-224"},
+		"hmac-sha3-256":    {f: (*Engine).hmac_sha3_256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA3-256"},
+		"hmac-sha3-384":    {f: (*Engine).hmac_sha3_384, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA3-384"},
+		"hmac-sha3-512":    {f: (*Engine).hmac_sha3_512, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA3-512"},
+		"hmac-sha512-224":  {f: (*Engine).hmac_sha512_224, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA512/224"},
+		"hmac-sha512-256":  {f: (*Engine).hmac_sha512_256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using SHA512/256"},
+		"hmac-blake2b-256": {f: (*Engine).hmac_blake2b_256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using BLAKE2b-256"},
+		"hmac-blake2b-384": {f: (*Engine).hmac_blake2b_384, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using BLAKE2b-384"},
+		"hmac-blake2b-512": {f: (*Engine).hmac_blake2b_512, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using BLAKE2b-512"},
+		"hmac-blake2s-256": {f: (*Engine).hmac_blake2s_256, In: "Data, Key", Out: "Hash", Desc: "HMAC hashes data using BLAKE2s-256"},
+
+		"mac-blake2b-256": {f: (*Engine).mac_blake2b_256, In: "Data, Key", Out: "Hash", Desc: "Hashes data using BLAKE2b-256 keyed with a key of up to 64 bytes, which is faster than HMAC"},
+		"mac-blake2b-384": {f: (*Engine).mac_blake2b_384, In: "Data, Key", Out: "Hash", Desc: "Hashes data using BLAKE2b-384 keyed with a key of up to 64 bytes, which is faster than HMAC"},
+		"mac-blake2b-512": {f: (*Engine).mac_blake2b_512, In: "Data, Key", Out: "Hash", Desc: "Hashes data using BLAKE2b-512 keyed with a key of up to 64 bytes, which is faster than HMAC"},
+		"mac-blake2s-256": {f: (*Engine).mac_blake2s_256, In: "Data, Key", Out: "Hash", Desc: "Hashes data using BLAKE2s-256 keyed with a key of up to 32 bytes, which is faster than HMAC"},
+	})
+
+	// encoding
+	add("Encoding", map[string]funcInfo{
+		"hex":          {f: (*Engine).hex, In: "Data", Out: "EncodedData", Desc: "Encode the data to hex"},
+		"unhex":        {f: (*Engine).unhex, In: "EncodedData", Out: "Data", Desc: "Decode the data from hex"},
+		"ascii85":      {f: (*Engine).ascii85, In: "Data", Out: "EncodedData", Desc: "Encode the data to ascii-85"},
+		"unascii85":    {f: (*Engine).unascii85, In: "EncodedData", Out: "Data", Desc: "Decode the data from ascii-85"},
+		"base32":       {f: (*Engine).base32, In: "Data", Out: "EncodedData", Desc: "Encode the data to base32"},
+		"unbase32":     {f: (*Engine).unbase32, In: "EncodedData", Out: "Data", Desc: "Decode the data from base32"},
+		"base32-hex":   {f: (*Engine).base32_hex, In: "Data", Out: "EncodedData", Desc: "Encode the data to base32 hex"},
+		"unbase32-hex": {f: (*Engine).unbase32_hex, In: "EncodedData", Out: "Data", Desc: "Decode the data from base32 hex"},
+		"base64":       {f: (*Engine).base64, In: "Data", Out: "EncodedData", Desc: "Encode the data to base64"},
+		"unbase64":     {f: (*Engine).unbase64, In: "EncodedData", Out: "Data", Desc: "Decode the data from base64"},
+		"base64-url":   {f: (*Engine).base64_url, In: "Data", Out: "EncodedData", Desc: "Encode the data to base64 url"},
+		"unbase64-url": {f: (*Engine).unbase64_url, In: "EncodedData", Out: "Data", Desc: "Decode the data from base64 url"},
+	})
+
+	// checksum hashing
+	add("Checksum", map[string]funcInfo{
+		"adler32":          {f: (*Engine).adler32, In: "Data", Out: "Checksum", Desc: "Compute the Adler-32 checksum"},
+		"crc32":            {f: (*Engine).crc32, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the IEEE polynomial"},
+		"crc32-ieee":       {f: (*Engine).crc32_ieee, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the IEEE polynomial"},
+		"crc32-castagnoli": {f: (*Engine).crc32_castagnoli, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the Castagnoli polynomial"},
+		"crc32-koopman":    {f: (*Engine).crc32_koopman, In: "Data", Out: "Checksum", Desc: "Compute the CRC-32 checksum using the Koopman polynomial"},
+		"crc64-iso":        {f: (*Engine).crc64_iso, In: "Data", Out: "Checksum", Desc: "Compute the CRC-64 checksum using the ISO polynomial"},
+		"crc64-ecma":       {f: (*Engine).crc64_ecma, In: "Data", Out: "Checksum", Desc: "Compute the CRC-64 checksum using the ECMA polynomial"},
+		"fnv32":            {f: (*Engine).fnv32, In: "Data", Out: "Hash", Desc: "Compute the FNV-1 non-cryptographic hash for 32-bits"},
+		"fnv32a":           {f: (*Engine).fnv32a, In: "Data", Out: "Hash", Desc: "Compute the FNV-1a non-cryptographic hash for 32-bits"},
+		"fnv64":            {f: (*Engine).fnv64, In: "Data", Out: "Hash", Desc: "Compute the FNV-1 non-cryptographic hash for 64-bits"},
+		"fnv64a":           {f: (*Engine).fnv64a, In: "Data", Out: "Hash", Desc: "Compute the FNV-1a non-cryptographic hash for 64-bits"},
+	})
+
+	// Compression
+	add("Compression", map[string]funcInfo{
+		"snappy":    {f: (*Engine).snappy, In: "Data", Out: "Compressed", Desc: "Compresses data using the Snappy algorithm"},
+		"unsnappy":  {f: (*Engine).unsnappy, In: "Compressed", Out: "Data", Desc: "Decompresses data using the Snappy algorithm"},
+		"zlib":      {f: (*Engine).zlib, In: "Data", Out: "Compressed", Desc: "Compresses data using the zlib algorithm"},
+		"unzlib":    {f: (*Engine).unzlib, In: "Compressed", Out: "Data", Desc: "Decompresses data using the zlib algorithm"},
+		"deflate":   {f: (*Engine).deflate, In: "Data, Factor", Out: "Compressed", Desc: "Compresses data using the flate algorithm - stack contains a compression factor where -1 is default and 0-9 controls compression (0 is none, and 9 is the most)"},
+		"inflate":   {f: (*Engine).inflate, In: "Compressed", Out: "Data", Desc: "Decompresses data using the flate algorithm"},
+		"gzip":      {f: (*Engine).gzip, In: "Data, Factor", Out: "Compressed", Desc: "Compresses data using the gzip algorithm - stack contains a compression factor where -1 is default, 0 is none, 1 is best speed, and 9 is best size"},
+		"ungzip":    {f: (*Engine).ungzip, In: "Compressed", Out: "Data", Desc: "Decompresses data using the gzip algorithm"},
+		"unbzip2":   {f: (*Engine).unbzip2, In: "Compressed", Out: "Data", Desc: "Decompresses data using the bzip2 algorithm"},
+		"lzw-msb":   {f: (*Engine).lzw_msb, In: "Data, Bits", Out: "Compressed", Desc: "Compresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses most significant bit ordering as used in the TIFF and PDF file formats."},
+		"lzw-lsb":   {f: (*Engine).lzw_lsb, In: "Data, Bits", Out: "Compressed", Desc: "Compresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses least significant bit ordering as used in the GIF file format."},
+		"unlzw-msb": {f: (*Engine).unlzw_msb, In: "Compressed, Bits", Out: "Data", Desc: "Decompresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses most significant bit ordering as used in the TIFF and PDF file formats."},
+		"unlzw-lsb": {f: (*Engine).unlzw_lsb, In: "Compressed, Bits", Out: "Data", Desc: "Decompresses data using the lzw algorithm - stack contains the number of bits to use for literal codes, typically 8 but can be 2-8. This version uses least significant bit ordering as used in the GIF file format."},
+	})
+
+	// control commands
+	add("Control", map[string]funcInfo{
+		"push":          {f: (*Engine).push, In: "Data", Out: "Data, Data", Desc: "Duplicates the value on the top of the stack"},
+		"pop":           {f: (*Engine).pop, In: "Data", Out: "", Desc: "Pops the value off the top of the stack (effectively discarding)"},
+		"load":          {f: (*Engine).load, In: "Name", Out: "Value", Desc: "Pushes a named value from the dictinary onto the stack"},
+		"save":          {f: (*Engine).save, In: "Value, Name", Out: "", Desc: "Pops a value from the stack and places it into the dictionary"},
+		"swap":          {f: (*Engine).swap, In: "Val1, Val2", Out: "Val2, Val1", Desc: "Swaps the two values at the top of the stack"},
+		"over":          {f: (*Engine).over, In: "Val1, Val2", Out: "Val1, Val2, Val1", Desc: "Copies the second value on the stack to the top"},
+		"rot":           {f: (*Engine).rot, In: "Val1, Val2, Val3", Out: "Val2, Val3, Val1", Desc: "Rotates the third value on the stack to the top"},
+		"-rot":          {f: (*Engine).unrot, In: "Val1, Val2, Val3", Out: "Val3, Val1, Val2", Desc: "Rotates the value on the top of the stack down to the third position"},
+		"nip":           {f: (*Engine).nip, In: "Val1, Val2", Out: "Val2", Desc: "Discards the second value on the stack"},
+		"tuck":          {f: (*Engine).tuck, In: "Val1, Val2", Out: "Val2, Val1, Val2", Desc: "Copies the value on the top of the stack below the second value"},
+		"pick":          {f: (*Engine).pick, In: "(varies)", Out: "(varies)", Desc: "Pops a position and copies the value at that position to the top of the stack, where 0 is the top. /0/pick is the same as push, and /1/pick is the same as over."},
+		"roll":          {f: (*Engine).roll, In: "(varies)", Out: "(varies)", Desc: "Pops a position and moves the value at that position to the top of the stack, where 0 is the top. /1/roll is the same as swap, and /2/roll is the same as rot."},
+		"depth":         {f: (*Engine).depth_, In: "", Out: "Depth", Desc: "Pushes the number of values on the stack"},
+		"clear":         {f: (*Engine).clear, In: "(varies)", Out: "", Desc: "Discards all of the values on the stack"},
+		"2dup":          {f: (*Engine).dup2, In: "Val1, Val2", Out: "Val1, Val2, Val1, Val2", Desc: "Duplicates the two values at the top of the stack"},
+		"2swap":         {f: (*Engine).swap2, In: "Val1, Val2, Val3, Val4", Out: "Val3, Val4, Val1, Val2", Desc: "Swaps the top two pairs of values on the stack"},
+		"append":        {f: (*Engine).append, In: "Val1, Val2", Out: "Appended", Desc: "Appends the value on the top of the stack to the previous value on the stack"},
+		"slice":         {f: (*Engine).slice, In: "Data, Start, End", Out: "SliceOfData", Desc: "Slices the value on the stack, taking elements from start to end on the stack. Use -1 for values from the beginning or end. One example is /9/20/slice which takes elements 9 through 19, or /2/-1/slice which takes elements 2 through the end."},
+		"len":           {f: (*Engine).len, In: "Data", Out: "Data, Length", Desc: "Pushes the length of the value on the stack in bytes onto the stack"},
+		"left":          {f: (*Engine).left, In: "Data, Count", Out: "SliceOfData", Desc: "Takes the leftmost bytes of data"},
+		"right":         {f: (*Engine).right, In: "Data, Count", Out: "SliceOfData", Desc: "Takes the rightmost bytes of data"},
+		"snip":          {f: (*Engine).snip, In: "Data, Position", Out: "Data1, Data2", Desc: "Snips the data in half at the given position, resulting in two values on the stack"},
+		"eq":            {f: (*Engine).eq, In: "Data1, Data2", Out: "", Desc: "Fails the command unless the two data elements are equal"},
+		"neq":           {f: (*Engine).neq, In: "Data1, Data2", Out: "", Desc: "Fails the command unless the two data elements are not equal"},
+		"ct-eq":         {f: (*Engine).ct_eq, In: "Data1, Data2", Out: "", Desc: "Fails the command unless the two data elements are equal, comparing them in constant time. Use this to check signatures."},
+		"ct-eq?":        {f: (*Engine).ct_eq_flag, In: "Data1, Data2", Out: "Flag", Desc: "Pushes 1 if the two data elements are equal, otherwise 0, comparing them in constant time"},
+		"foreach":       {f: (*Engine).foreach, In: "Data, Name", Out: "Results", Desc: "Splits the data into lines and executes the named program from the dictionary on each line with its own stack, joining the results with newlines. Each run must leave exactly one value on its stack."},
+		"foreach-split": {f: (*Engine).foreach_split, In: "Data, Delimiter, Name", Out: "Results", Desc: "Splits the data on the delimiter and executes the named program from the dictionary on each element with its own stack, joining the results with the delimiter. Each run must leave exactly one value on its stack."},
+		"if":            {f: (*Engine).if_, In: "Flag, Then, Else", Out: "(varies)", Desc: "Executes the program named by Then if the flag is true (not empty and not 0), otherwise executes the program named by Else"},
+		"ifeq":          {f: (*Engine).ifeq, In: "Data1, Data2, Then, Else", Out: "(varies)", Desc: "Executes the program named by Then if the two data elements are equal, otherwise executes the program named by Else"},
+		"ifneq":         {f: (*Engine).ifneq, In: "Data1, Data2, Then, Else", Out: "(varies)", Desc: "Executes the program named by Then if the two data elements are not equal, otherwise executes the program named by Else"},
+		"try":           {f: (*Engine).try, In: "Name, Handler", Out: "(varies)", Desc: "Executes the named program. If it fails, the stack is restored to how it was before the program ran, the error message is saved in the error variable, and the Handler program is executed instead."},
+		"call":          {f: (*Engine).call, In: "Name", Out: "(varies)", Desc: "Loads the named value from the dictionary and executes the commands contained there (formatted like normal - /md5/hex for example)"},
+	})
+
+	// text
+	add("Text", map[string]funcInfo{
+		"lower":         {f: (*Engine).lower, In: "Text", Out: "Text", Desc: "Converts UTF-8 text to lower case"},
+		"upper":         {f: (*Engine).upper, In: "Text", Out: "Text", Desc: "Converts UTF-8 text to upper case"},
+		"trim":          {f: (*Engine).trim, In: "Text", Out: "Text", Desc: "Removes leading and trailing white space"},
+		"replace":       {f: (*Engine).replace, In: "Data, Old, New", Out: "Replaced", Desc: "Replaces every occurrence of Old in the data with New"},
+		"regex-match":   {f: (*Engine).regex_match, In: "Data, Pattern", Out: "Flag", Desc: "Pushes 1 if the data matches the regular expression, otherwise 0"},
+		"regex-replace": {f: (*Engine).regex_replace, In: "Data, Pattern, Replacement", Out: "Replaced", Desc: "Replaces every match of the regular expression in the data. The replacement may refer to submatches using $1 or ${name}."},
+		"split":         {f: (*Engine).split, In: "Data, Separator", Out: "(varies)", Desc: "Splits the data on the separator, pushing each part followed by the number of parts"},
+		"join":          {f: (*Engine).join, In: "(varies)", Out: "Joined", Desc: "Pops a separator and a count, then joins that many values from the stack using the separator. Undoes split, as in /,/split/,/join."},
+	})
+
+	// bitwise and byte-level
+	add("Bytes", map[string]funcInfo{
+		"xor":       {f: (*Engine).xor, In: "Val1, Val2", Out: "Result", Desc: "Computes the exclusive or of two values of the same length"},
+		"and":       {f: (*Engine).and, In: "Val1, Val2", Out: "Result", Desc: "Computes the bitwise and of two values of the same length"},
+		"or":        {f: (*Engine).or, In: "Val1, Val2", Out: "Result", Desc: "Computes the bitwise or of two values of the same length"},
+		"not":       {f: (*Engine).not, In: "Data", Out: "Result", Desc: "Inverts every bit of the data"},
+		"reverse":   {f: (*Engine).reverse, In: "Data", Out: "Reversed", Desc: "Reverses the order of the bytes of the data"},
+		"repeat":    {f: (*Engine).repeat, In: "Data, Count", Out: "Repeated", Desc: "Repeats the data the given number of times"},
+		"pad-left":  {f: (*Engine).pad_left, In: "Data, Length, Fill", Out: "Padded", Desc: "Pads the data on the left to the given length using the single byte fill value. Data that is already long enough is unchanged."},
+		"pad-right": {f: (*Engine).pad_right, In: "Data, Length, Fill", Out: "Padded", Desc: "Pads the data on the right to the given length using the single byte fill value. Data that is already long enough is unchanged."},
+		"zeros":     {f: (*Engine).zeros, In: "Count", Out: "Data", Desc: "Pushes the given number of zero bytes"},
+		"byte-at":   {f: (*Engine).byte_at, In: "Data, Index", Out: "Byte", Desc: "Pushes the value of the byte at the given index (starting at 0) as a decimal integer from 0 to 255"},
+	})
+
+	// arithmetic
+	add("Arithmetic", map[string]funcInfo{
+		"add": {f: (*Engine).add, In: "Int1, Int2", Out: "Sum", Desc: "Adds two decimal integers"},
+		"sub": {f: (*Engine).sub, In: "Int1, Int2", Out: "Difference", Desc: "Subtracts the integer on the top of the stack from the one below it, so /7/3/sub is 4"},
+		"mul": {f: (*Engine).mul, In: "Int1, Int2", Out: "Product", Desc: "Multiplies two decimal integers"},
+		"div": {f: (*Engine).div, In: "Int1, Int2", Out: "Quotient", Desc: "Divides Int1 by Int2, truncating toward zero"},
+		"mod": {f: (*Engine).mod, In: "Int1, Int2", Out: "Remainder", Desc: "Computes the remainder of dividing Int1 by Int2"},
+		"min": {f: (*Engine).min, In: "Int1, Int2", Out: "Int", Desc: "Pushes the smaller of two decimal integers"},
+		"max": {f: (*Engine).max, In: "Int1, Int2", Out: "Int", Desc: "Pushes the larger of two decimal integers"},
+		"lt":  {f: (*Engine).lt, In: "Int1, Int2", Out: "Flag", Desc: "Pushes 1 if Int1 is less than Int2, otherwise 0"},
+		"gt":  {f: (*Engine).gt, In: "Int1, Int2", Out: "Flag", Desc: "Pushes 1 if Int1 is greater than Int2, otherwise 0"},
+		"le":  {f: (*Engine).le, In: "Int1, Int2", Out: "Flag", Desc: "Pushes 1 if Int1 is less than or equal to Int2, otherwise 0"},
+		"ge":  {f: (*Engine).ge, In: "Int1, Int2", Out: "Flag", Desc: "Pushes 1 if Int1 is greater than or equal to Int2, otherwise 0"},
+		"inc": {f: (*Engine).inc, In: "Int", Out: "Int", Desc: "Adds one to a decimal integer"},
+		"dec": {f: (*Engine).dec, In: "Int", Out: "Int", Desc: "Subtracts one from a decimal integer"},
+	})
+
+	// Crypto
+	add("Crypto", map[string]funcInfo{
+		"protect":       {f: (*Engine).protect, In: "Data", Out: "ProtectedData", Desc: "Encrypts data with the active key in the keyring using AES-GCM, producing an envelope that names the key"},
+		"unprotect":     {f: (*Engine).unprotect, In: "ProtectedData", Out: "Data", Desc: "Decrypts an envelope made by protect using the key it names, verifying that it was not changed"},
+		"aes-cfb":       {f: (*Engine).aes_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 16-byte Key, placing the ciphertext back on the stack. Uses AES encryption and the CFB block mode."},
+		"unaes-cfb":     {f: (*Engine).unaes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 16-byte Key, placing the plaintext back on the stack. Uses AES encryption and the CFB block mode."},
+		"aes-ofb":       {f: (*Engine).aes_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16-byte Key, placing the result back on the stack. Uses AES encryption and the OFB block mode."},
+		"aes-ctr":       {f: (*Engine).aes_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16-byte Key, placing the result back on the stack. Uses AES encryption and the CTR block mode."},
+		"aes-blocksize": {f: (*Engine).aes_blocksize, In: "", Out: "16", Desc: "Pushes the AES block size on the stack"},
+
+		"des-cfb":   {f: (*Engine).des_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 8-byte Key, placing the ciphertext back on the stack. Uses DES encryption and the CFB block mode."},
+		"undes-cfb": {f: (*Engine).undes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 8-byte Key, placing the plaintext back on the stack. Uses DES encryption and the CFB block mode."},
+		"des-ofb":   {f: (*Engine).des_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 8-byte Key, placing the result back on the stack. Uses DES encryption and the OFB block mode."},
+		"des-ctr":   {f: (*Engine).des_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 8-byte Key, placing the result back on the stack. Uses DES encryption and the CTR block mode."},
+
+		"3des-cfb":       {f: (*Engine).tripledes_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 24-byte Key, placing the ciphertext back on the stack. Uses Triple DES encryption and the CFB block mode."},
+		"un3des-cfb":     {f: (*Engine).untripledes_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 24-byte Key, placing the plaintext back on the stack. Uses Triple DES encryption and the CFB block mode."},
+		"3des-ofb":       {f: (*Engine).tripledes_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 24-byte Key, placing the result back on the stack. Uses Triple DES encryption and the OFB block mode."},
+		"3des-ctr":       {f: (*Engine).tripledes_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 24-byte Key, placing the result back on the stack. Uses Triple DES encryption and the CTR block mode."},
+		"des-blocksize":  {f: (*Engine).des_blocksize, In: "", Out: "8", Desc: "Pushes the DES block size on the stack"},
+		"3des-blocksize": {f: (*Engine).des_blocksize, In: "", Out: "8", Desc: "Pushes the Triple DES block size on the stack"},
+
+		"blowfish-cfb":       {f: (*Engine).blowfish_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 1 to 56-byte Key, placing the ciphertext back on the stack. Uses Blowfish encryption and the CFB block mode."},
+		"unblowfish-cfb":     {f: (*Engine).unblowfish_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 1 to 56-byte Key, placing the plaintext back on the stack. Uses Blowfish encryption and the CFB block mode."},
+		"blowfish-ofb":       {f: (*Engine).blowfish_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the OFB block mode."},
+		"blowfish-ctr":       {f: (*Engine).blowfish_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the CTR block mode."},
+		"blowfish-blocksize": {f: (*Engine).blowfish_blocksize, In: "", Out: "8", Desc: "Pushes the blowfish block size on the stack"},
+
+		"blowfish-salt-cfb":   {f: (*Engine).blowfish_salt_cfb, In: "PlainData, IV, Key, Salt", Out: "CipherData", Desc: "Encrypts data using the given IV and 1 to 56-byte Key, placing the ciphertext back on the stack. Uses Blowfish encryption and the CFB block mode."},
+		"unblowfish-salt-cfb": {f: (*Engine).unblowfish_salt_cfb, In: "CipherData, IV, Key, Salt", Out: "PlainData", Desc: "Decrypts data using the given IV and 1 to 56-byte Key, placing the plaintext back on the stack. Uses Blowfish encryption and the CFB block mode."},
+		"blowfish-salt-ofb":   {f: (*Engine).blowfish_salt_ofb, In: "Data, IV, Key, Salt", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the OFB block mode."},
+		"blowfish-salt-ctr":   {f: (*Engine).blowfish_salt_ctr, In: "Data, IV, Key, Salt", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 1 to 56-byte Key, placing the result back on the stack. Uses Blowfish encryption and the CTR block mode."},
+
+		"twofish-cfb":       {f: (*Engine).twofish_cfb, In: "PlainData, IV, Key", Out: "CipherData", Desc: "Encrypts data using the given IV and 16, 24, or 32-byte Key, placing the ciphertext back on the stack. Uses Twofish encryption and the CFB block mode."},
+		"untwofish-cfb":     {f: (*Engine).untwofish_cfb, In: "CipherData, IV, Key", Out: "PlainData", Desc: "Decrypts data using the given IV and 16, 24, or 32-byte Key, placing the plaintext back on the stack. Uses Twofish encryption and the CFB block mode."},
+		"twofish-ofb":       {f: (*Engine).twofish_ofb, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16, 24, or 32-byte Key, placing the result back on the stack. Uses Twofish encryption and the OFB block mode."},
+		"twofish-ctr":       {f: (*Engine).twofish_ctr, In: "Data, IV, Key", Out: "RData", Desc: "Encrypts or decrypts data using the given IV and 16, 24, or 32-byte Key, placing the result back on the stack. Uses Twofish encryption and the CTR block mode."},
+		"twofish-blocksize": {f: (*Engine).twofish_blocksize, In: "", Out: "16", Desc: "Pushes the twofish block size on the stack"},
+	})
 }
@@ -2,10 +2,23 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -16,6 +29,7 @@ type TestCase struct {
 	name         string
 	initialStack [][]byte
 	commands     string
+	vars         map[string]string
 	result       []byte
 }
 
@@ -46,6 +60,45 @@ var testCases = []TestCase{
 	{name: "sha512 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha512/len/swap/pop/sha512-len/eq", result: []byte("Hello")},
 	{name: "ripemd160 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/ripemd160/len/swap/pop/ripemd160-len/eq", result: []byte("Hello")},
 
+	{name: "sha3-224", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha3-224/hex", result: []byte("4cf679344af02c2b89e4a902f939f4608bcac0fbf81511da13d7d9b9")},
+	{name: "sha3-256", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha3-256/hex", result: []byte("8ca66ee6b2fe4bb928a8e3cd2f508de4119c0895f22e011117e22cf9b13de7ef")},
+	{name: "sha3-384", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha3-384/hex", result: []byte("df7e26e3d067579481501057c43aea61035c8ffdf12d9ae427ef4038ad7c13266a11c0a3896adef37ad1bc85a2b5bdac")},
+	{name: "sha3-512", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha3-512/hex", result: []byte("0b8a44ac991e2b263e8623cfbeefc1cffe8c1c0de57b3e2bf1673b4f35e660e89abd18afb7ac93cf215eba36dd1af67698d6c9ca3fdaaf734ffc4bd5a8e34627")},
+	{name: "sha512-224", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha512-224/hex", result: []byte("0d075258abfd1f8b81fc0a5207a1aa5cc82eb287720b1f849b862235")},
+	{name: "sha512-256", initialStack: [][]byte{[]byte("Hello")}, commands: "/sha512-256/hex", result: []byte("7e75b18b88d2cb8be95b05ec611e54e2460408a2dcf858f945686446c9d07aac")},
+	{name: "blake2b-256", initialStack: [][]byte{[]byte("Hello")}, commands: "/blake2b-256/hex", result: []byte("8b7ca7d27d9fc55fa30abfe515b3afb24e3fe89fdd02e2ac92bca2c96680642e")},
+	{name: "blake2b-384", initialStack: [][]byte{[]byte("Hello")}, commands: "/blake2b-384/hex", result: []byte("bc3868e9afae58a3c910c16ff94d1a6185e8c588f77132979395f9d01126aafe32770cfb33a8a84dddd0bf0c826cb9eb")},
+	{name: "blake2b-512", initialStack: [][]byte{[]byte("Hello")}, commands: "/blake2b-512/hex", result: []byte("ef15eaf92d5e335345a3e1d977bc7d8797c3d275717cc1b10af79c93cda01aeb2a0c59bc02e2bdf9380fd1b54eb9e1669026930ccc24bd49748e65f9a6b2ee68")},
+	{name: "blake2s-256", initialStack: [][]byte{[]byte("Hello")}, commands: "/blake2s-256/hex", result: []byte("f73a5fbf881f89b814871f46e26ad3fa37cb2921c5e8561618639015b3ccbb71")},
+	{name: "shake128", initialStack: [][]byte{[]byte("Hello")}, commands: "/32/shake128/hex", result: []byte("4131f8db5745776b48b86caa68d251fa9b19cf46b92b16289bb0c98e57e0e0de")},
+	{name: "shake128 short", initialStack: [][]byte{[]byte("Hello")}, commands: "/10/shake128/hex", result: []byte("4131f8db5745776b48b8")},
+	{name: "shake256", initialStack: [][]byte{[]byte("Hello")}, commands: "/64/shake256/hex", result: []byte("555796c90bfb8f3256a1cb0d7e574877fd48750e4147cf40aa43da122b4d64dafec00acf1ff59f4c3805f9589ec1ee3b712bc3701ce7d825cba56ca4942f6ffd")},
+	{name: "shake256 empty", initialStack: [][]byte{[]byte("Hello")}, commands: "/0/shake256/len/swap/pop", result: []byte("0")},
+	{name: "hmac-sha3-224", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha3-224/hmac-sha3-224/hex", result: []byte("5003aa2352f062097648178ff028423a17584a3918c922cf9414706f")},
+	{name: "hmac-sha3-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha3-256/hmac-sha3-256/hex", result: []byte("89b55d2abe672c5ab20f98d4812a41cd5fe5150d550733ba780acbf2abec050a")},
+	{name: "hmac-sha3-384", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha3-384/hmac-sha3-384/hex", result: []byte("6bfab35786df77ce04208a9a28d3768f5feefcbf96cc73456164ab9ca4a74acf58f1348a6820e8ae3ab5bd3f2991fe5b")},
+	{name: "hmac-sha3-512", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha3-512/hmac-sha3-512/hex", result: []byte("5fe143908892c433c5a25062b52b60eed2883a7921dba3b6e6de002f54e987961237615015ed6b6aa5c17cbe3fee5d31a27dfbd39b7175f979fed71edaff8cb1")},
+	{name: "hmac-sha512-224", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha512-224/hmac-sha512-224/hex", result: []byte("6b751c2a5165714d3d3c1e80c78f3431d4caca5f6fd9605aadba60ef")},
+	{name: "hmac-sha512-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/sha512-256/hmac-sha512-256/hex", result: []byte("d29cdf8ce6741337c953bb2dfc564eba4c7d3b408310c817c965c32ea7f9be96")},
+	{name: "hmac-blake2b-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/blake2b-256/hmac-blake2b-256/hex", result: []byte("c30248c9a83858ecfaf842c2952a49e4733e116e46398fa9cedfa69d6ccc4038")},
+	{name: "hmac-blake2b-384", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/blake2b-384/hmac-blake2b-384/hex", result: []byte("a858c2e4e91d23be582b933476548e59c347bd65ac5ff2938a879df4310d5f9b135f58fc0e345a023882803c718e0977")},
+	{name: "hmac-blake2b-512", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/blake2b-512/hmac-blake2b-512/hex", result: []byte("e3799cef224b321c393a2e90a7dca565f67d35df5012d7ba1d6063cb3389ac8c207f838f60998d6bd7bbe547f8182a8bfb925d7f3afa4fcc61a98bfa31e1ee9b")},
+	{name: "hmac-blake2s-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/blake2s-256/hmac-blake2s-256/hex", result: []byte("99c841d1fe03068129b873f7808fc8a0ddefa949a87ca5a7774b2f9300b94695")},
+	{name: "mac-blake2b-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/mac-blake2b-256/hex", result: []byte("15d85f19f406600e52396675fbc09cb8cecc96501cb6f7e6a91335e9321b4756")},
+	{name: "mac-blake2b-384", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/mac-blake2b-384/hex", result: []byte("c010744bcacb2585c49eb94e87f423842e9ad17f4def5531c57784c106f9e7d4b9ca1643a5c4561e961860fb8859df28")},
+	{name: "mac-blake2b-512", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/mac-blake2b-512/hex", result: []byte("eee0e8ad5797473f54bd13ab2963b99f5bb2ae3a3f1760474858ff4f29fd1d121c7d8f4b92b9ede46d9a1cdf4743b682da60371ab7c3fd9f205e6339c51828e8")},
+	{name: "mac-blake2s-256", initialStack: [][]byte{[]byte("TheData"), []byte("TheKey")}, commands: "/mac-blake2s-256/hex", result: []byte("0ee055f69755d83afebed2f426962f025a8cc5aab9a23614af0cc2dcc2eea78e")},
+	{name: "sha3-224 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha3-224/len/swap/pop/sha3-224-len/eq", result: []byte("Hello")},
+	{name: "sha3-256 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha3-256/len/swap/pop/sha3-256-len/eq", result: []byte("Hello")},
+	{name: "sha3-384 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha3-384/len/swap/pop/sha3-384-len/eq", result: []byte("Hello")},
+	{name: "sha3-512 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha3-512/len/swap/pop/sha3-512-len/eq", result: []byte("Hello")},
+	{name: "sha512-224 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha512-224/len/swap/pop/sha512-224-len/eq", result: []byte("Hello")},
+	{name: "sha512-256 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/sha512-256/len/swap/pop/sha512-256-len/eq", result: []byte("Hello")},
+	{name: "blake2b-256 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/blake2b-256/len/swap/pop/blake2b-256-len/eq", result: []byte("Hello")},
+	{name: "blake2b-384 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/blake2b-384/len/swap/pop/blake2b-384-len/eq", result: []byte("Hello")},
+	{name: "blake2b-512 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/blake2b-512/len/swap/pop/blake2b-512-len/eq", result: []byte("Hello")},
+	{name: "blake2s-256 len", initialStack: [][]byte{[]byte("Hello")}, commands: "/push/blake2s-256/len/swap/pop/blake2s-256-len/eq", result: []byte("Hello")},
+
 	// compression
 	{name: "snappy", initialStack: [][]byte{[]byte("This is some data we might compress")}, commands: "/snappy/unsnappy", result: []byte("This is some data we might compress")},
 	{name: "snappy2", initialStack: [][]byte{[]byte("Hello this is a test")}, commands: "/snappy/hex", result: []byte("144c48656c6c6f207468697320697320612074657374")},
@@ -80,9 +133,97 @@ var testCases = []TestCase{
 	{name: "snip", initialStack: [][]byte{[]byte("ABC")}, commands: "/2/snip/-/swap/append/append", result: []byte("AB-C")},
 	{name: "snip", initialStack: [][]byte{[]byte("ABC")}, commands: "/3/snip/-/swap/append/append", result: []byte("ABC-")},
 	{name: "snip", initialStack: [][]byte{[]byte("ABC")}, commands: "/4/snip/-/swap/append/append", result: []byte("ABC-")},
+	{name: "over", initialStack: [][]byte{}, commands: "/A/B/over/append/append", result: []byte("ABA")},
+	{name: "rot", initialStack: [][]byte{}, commands: "/A/B/C/rot/append/append", result: []byte("BCA")},
+	{name: "-rot", initialStack: [][]byte{}, commands: "/A/B/C/-rot/append/append", result: []byte("CAB")},
+	{name: "nip", initialStack: [][]byte{}, commands: "/A/B/nip", result: []byte("B")},
+	{name: "tuck", initialStack: [][]byte{}, commands: "/A/B/tuck/append/append", result: []byte("BAB")},
+	{name: "pick", initialStack: [][]byte{}, commands: "/A/B/C/2/pick/append/append/append", result: []byte("ABCA")},
+	{name: "pick0", initialStack: [][]byte{}, commands: "/A/0/pick/append", result: []byte("AA")},
+	{name: "roll", initialStack: [][]byte{}, commands: "/A/B/C/2/roll/append/append", result: []byte("BCA")},
+	{name: "roll1", initialStack: [][]byte{}, commands: "/A/B/1/roll/append", result: []byte("BA")},
+	{name: "depth", initialStack: [][]byte{}, commands: "/A/B/depth/append/append", result: []byte("AB2")},
+	{name: "clear", initialStack: [][]byte{[]byte("ABC")}, commands: "/A/B/clear/C", result: []byte("C")},
+	{name: "2dup", initialStack: [][]byte{}, commands: "/A/B/2dup/append/append/append", result: []byte("ABAB")},
+	{name: "2swap", initialStack: [][]byte{}, commands: "/A/B/C/D/2swap/append/append/append", result: []byte("CDAB")},
 	{name: "eq", initialStack: [][]byte{[]byte("ABC")}, commands: "/DEF/DEF/eq", result: []byte("ABC")},
+	{name: "ct-eq", initialStack: [][]byte{[]byte("ABC")}, commands: "/DEF/DEF/ct-eq", result: []byte("ABC")},
+	{name: "ct-eq?", initialStack: [][]byte{}, commands: "/DEF/DEF/ct-eq?", result: []byte("1")},
+	{name: "ct-eq? not equal", initialStack: [][]byte{}, commands: "/DEF/DE/ct-eq?", result: []byte("0")},
+	{name: "ct-eq? if", initialStack: [][]byte{}, vars: map[string]string{"yes": "/valid", "no": "/invalid"}, commands: "/DEF/DEG/ct-eq?/yes/no/if", result: []byte("invalid")},
 	{name: "neq", initialStack: [][]byte{[]byte("ABC")}, commands: "/DEF/EFG/neq", result: []byte("ABC")},
 
+	// foreach
+	{name: "foreach", initialStack: [][]byte{[]byte("a\nb\n")}, vars: map[string]string{"each": "/md5/hex"}, commands: "/each/foreach", result: []byte("0cc175b9c0f1b6a831c399e269772661\n92eb5ffee6ae2fec3ad71c777531578f\n")},
+	{name: "foreach crlf", initialStack: [][]byte{[]byte("a\r\nb")}, vars: map[string]string{"each": "/md5/hex"}, commands: "/each/foreach", result: []byte("0cc175b9c0f1b6a831c399e269772661\n92eb5ffee6ae2fec3ad71c777531578f")},
+	{name: "foreach empty", initialStack: [][]byte{[]byte("")}, vars: map[string]string{"each": "/md5/hex"}, commands: "/each/foreach", result: []byte("")},
+	{name: "foreach-split", initialStack: [][]byte{[]byte("AB,CD,EF")}, vars: map[string]string{"each": "/1/left"}, commands: "/,/each/foreach-split", result: []byte("A,C,E")},
+	{name: "foreach isolated", initialStack: [][]byte{[]byte("x\ny")}, vars: map[string]string{"each": "/len/swap/pop"}, commands: "/FOO/swap/each/foreach/swap/pop", result: []byte("1\n1")},
+
+	// literals
+	{name: "quoted literal", initialStack: [][]byte{}, commands: "/'md5", result: []byte("md5")},
+	{name: "quoted empty", initialStack: [][]byte{}, commands: "/'/len/swap/pop", result: []byte("0")},
+	{name: "hex literal", initialStack: [][]byte{}, commands: "/hex:414243", result: []byte("ABC")},
+	{name: "b64 literal", initialStack: [][]byte{}, commands: "/b64:QUJD", result: []byte("ABC")},
+	{name: "b64url literal", initialStack: [][]byte{}, commands: "/b64url:P_8=/hex", result: []byte("3fff")},
+	{name: "literal keyword", initialStack: [][]byte{[]byte("Hello")}, commands: "/'md5/pop/md5/hex", result: []byte("8b1a9953c4611296a827abf8c47804d7")},
+
+	// conditionals
+	{name: "ifeq", initialStack: [][]byte{}, vars: map[string]string{"yes": "/YES", "no": "/NO"}, commands: "/A/A/yes/no/ifeq", result: []byte("YES")},
+	{name: "ifeq else", initialStack: [][]byte{}, vars: map[string]string{"yes": "/YES", "no": "/NO"}, commands: "/A/B/yes/no/ifeq", result: []byte("NO")},
+	{name: "ifneq", initialStack: [][]byte{}, vars: map[string]string{"yes": "/YES", "no": "/NO"}, commands: "/A/B/yes/no/ifneq", result: []byte("YES")},
+	{name: "if", initialStack: [][]byte{}, vars: map[string]string{"yes": "/YES", "no": "/NO"}, commands: "/1/yes/no/if", result: []byte("YES")},
+	{name: "if zero", initialStack: [][]byte{}, vars: map[string]string{"yes": "/YES", "no": "/NO"}, commands: "/0/yes/no/if", result: []byte("NO")},
+	{name: "if nop", initialStack: [][]byte{[]byte("ABC")}, vars: map[string]string{"yes": "/pop/YES"}, commands: "/0/yes/nop/if", result: []byte("ABC")},
+	{name: "try", initialStack: [][]byte{[]byte("414243")}, vars: map[string]string{"decode": "/unhex", "h": "/pop/BAD"}, commands: "/decode/h/try", result: []byte("ABC")},
+	{name: "try error", initialStack: [][]byte{[]byte("ABC")}, vars: map[string]string{"bad": "/zz/unhex", "h": "/pop/error/load"}, commands: "/bad/h/try", result: []byte("encoding/hex: invalid byte: U+007A 'z'")},
+	{name: "try checksig valid", initialStack: [][]byte{[]byte("ABC")}, vars: map[string]string{"check": "/checksig-sha256/call/pop/valid", "fail": "/pop/invalid"}, commands: "/sign-sha256/call/check/fail/try", result: []byte("valid")},
+	{name: "try checksig invalid", initialStack: [][]byte{[]byte("ABC")}, vars: map[string]string{"check": "/checksig-sha256/call/pop/valid", "fail": "/pop/invalid"}, commands: "/sign-sha256/call/X/append/check/fail/try", result: []byte("invalid")},
+
+	// text
+	{name: "lower", initialStack: [][]byte{[]byte("ABC-Def")}, commands: "/lower", result: []byte("abc-def")},
+	{name: "upper", initialStack: [][]byte{[]byte("ABC-Def")}, commands: "/upper", result: []byte("ABC-DEF")},
+	{name: "trim", initialStack: [][]byte{[]byte(" \tABC\r\n")}, commands: "/trim", result: []byte("ABC")},
+	{name: "replace", initialStack: [][]byte{[]byte("a\r\nb\r\n")}, commands: "/hex:0d0a/hex:0a/replace", result: []byte("a\nb\n")},
+	{name: "regex-match", initialStack: [][]byte{[]byte("id-1234")}, commands: "/^id-[0-9]+$/regex-match", result: []byte("1")},
+	{name: "regex-match no", initialStack: [][]byte{[]byte("id-12a4")}, commands: "/^id-[0-9]+$/regex-match", result: []byte("0")},
+	{name: "regex-replace", initialStack: [][]byte{[]byte("id-1234")}, commands: "/([a-z]+)-([0-9]+)/$2:$1/regex-replace", result: []byte("1234:id")},
+	{name: "split count", initialStack: [][]byte{[]byte("a,b,c")}, commands: "/,/split/swap/pop/swap/pop/swap/pop", result: []byte("3")},
+	{name: "split join", initialStack: [][]byte{[]byte("a,b,c")}, commands: "/,/split/;/join", result: []byte("a;b;c")},
+	{name: "join", initialStack: [][]byte{}, commands: "/a/b/2/'/join", result: []byte("ab")},
+
+	// bitwise
+	{name: "xor", initialStack: [][]byte{}, commands: "/hex:0f0f/hex:ff00/xor/hex", result: []byte("f00f")},
+	{name: "and", initialStack: [][]byte{}, commands: "/hex:0f0f/hex:ff00/and/hex", result: []byte("0f00")},
+	{name: "or", initialStack: [][]byte{}, commands: "/hex:0f0f/hex:ff00/or/hex", result: []byte("ff0f")},
+	{name: "not", initialStack: [][]byte{}, commands: "/hex:0f00/not/hex", result: []byte("f0ff")},
+	{name: "reverse", initialStack: [][]byte{}, commands: "/ABC/reverse", result: []byte("CBA")},
+	{name: "repeat", initialStack: [][]byte{}, commands: "/AB/3/repeat", result: []byte("ABABAB")},
+	{name: "repeat0", initialStack: [][]byte{}, commands: "/AB/0/repeat", result: []byte("")},
+	{name: "pad-left", initialStack: [][]byte{}, commands: "/7/4/'0/pad-left", result: []byte("0007")},
+	{name: "pad-right", initialStack: [][]byte{}, commands: "/AB/4/hex:00/pad-right/hex", result: []byte("41420000")},
+	{name: "pad long", initialStack: [][]byte{}, commands: "/ABCDE/4/hex:00/pad-right", result: []byte("ABCDE")},
+	{name: "zeros", initialStack: [][]byte{}, commands: "/3/zeros/hex", result: []byte("000000")},
+	{name: "byte-at", initialStack: [][]byte{}, commands: "/hex:00ff10/1/byte-at", result: []byte("255")},
+
+	// arithmetic
+	{name: "add", initialStack: [][]byte{}, commands: "/7/3/add", result: []byte("10")},
+	{name: "sub", initialStack: [][]byte{}, commands: "/7/3/sub", result: []byte("4")},
+	{name: "sub negative", initialStack: [][]byte{}, commands: "/3/7/sub", result: []byte("-4")},
+	{name: "mul", initialStack: [][]byte{}, commands: "/7/-3/mul", result: []byte("-21")},
+	{name: "div", initialStack: [][]byte{}, commands: "/-7/2/div", result: []byte("-3")},
+	{name: "mod", initialStack: [][]byte{}, commands: "/7/3/mod", result: []byte("1")},
+	{name: "min", initialStack: [][]byte{}, commands: "/7/3/min", result: []byte("3")},
+	{name: "max", initialStack: [][]byte{}, commands: "/7/3/max", result: []byte("7")},
+	{name: "lt", initialStack: [][]byte{}, commands: "/3/7/lt", result: []byte("1")},
+	{name: "gt", initialStack: [][]byte{}, commands: "/3/7/gt", result: []byte("0")},
+	{name: "le", initialStack: [][]byte{}, commands: "/7/7/le", result: []byte("1")},
+	{name: "ge", initialStack: [][]byte{}, commands: "/6/7/ge", result: []byte("0")},
+	{name: "inc", initialStack: [][]byte{}, commands: "/9/inc", result: []byte("10")},
+	{name: "dec", initialStack: [][]byte{}, commands: "/0/dec", result: []byte("-1")},
+	{name: "64-bit", initialStack: [][]byte{}, commands: "/4294967296/4294967296/add", result: []byte("8589934592")},
+	{name: "envelope offset", initialStack: [][]byte{[]byte("0123456789012345678901234567890123456789")}, commands: "/len/16/sub/12/sub/swap/pop", result: []byte("12")},
+
 	// call
 	{name: "call twofish", initialStack: [][]byte{[]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")}, commands: "/encrypt-twofish/call/decrypt-twofish/call", result: []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")},
 	{name: "call blowfish", initialStack: [][]byte{[]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")}, commands: "/encrypt-blowfish/call/decrypt-blowfish/call", result: []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")},
@@ -141,6 +282,9 @@ func TestEngine(t *testing.T) {
 	for _, testCase := range testCases {
 		// Initialize engine and initial value
 		eng.Reset()
+		for k, v := range testCase.vars {
+			eng.SetVariable(k, []byte(v))
+		}
 		for i, entry := range testCase.initialStack {
 			eng.PushStack(entry)
 			if i == 0 {
@@ -163,3 +307,1258 @@ func TestEngine(t *testing.T) {
 		}
 	}
 }
+
+func TestHashMacros(t *testing.T) {
+	for _, alg := range []string{"sha3-224", "sha3-256", "sha3-384", "sha3-512", "sha512-224", "sha512-256", "blake2b-256", "blake2b-384", "blake2b-512", "blake2s-256"} {
+		eng := New()
+		eng.PushStack([]byte("ABC"))
+		want, err := eng.Run(parseCommands("/key/load/" + alg + "/hmac-" + alg))
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		eng.PushStack([]byte("ABC"))
+		if got, err := eng.Run(parseCommands("/hash-hmac-" + alg + "/call")); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("hash-hmac-%s: got %x, %v; expected %x", alg, got, err, want)
+		}
+		eng.PushStack([]byte("ABC"))
+		if got, err := eng.Run(parseCommands("/sign-" + alg + "/call/checksig-" + alg + "/call")); err != nil || string(got) != "ABC" {
+			t.Errorf("sign-%s and checksig-%s: got %q, %v", alg, alg, got, err)
+		}
+	}
+}
+
+func TestForeachError(t *testing.T) {
+	eng := New()
+	eng.SetVariable("each", []byte("/unhex"))
+	eng.PushStack([]byte("00\nzz\n"))
+	_, err := eng.Run([]string{"each", "foreach"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error to mention line 2, got %q", err.Error())
+	}
+
+	// a missing program is reported by the command that wanted it
+	eng.Reset()
+	eng.PushStack([]byte("a,b"))
+	_, err = eng.Run([]string{",", "missing", "foreach-split"})
+	var invalid *InvalidArgumentError
+	if !errors.As(err, &invalid) || invalid.Op != "foreach-split" {
+		t.Errorf("expected an error from foreach-split, got %v", err)
+	}
+}
+
+func TestBadLiteral(t *testing.T) {
+	eng := New()
+	_, err := eng.Run([]string{"hex:zz"})
+	if err == nil {
+		t.Error("expected an error for an invalid hex literal")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	p, err := Compile([]string{"sha256", "hex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := New()
+	for _, tc := range []struct{ in, out string }{
+		{"Hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"},
+		{"TheData", "82ebce5380a936431850b943ae1230e1a2a761b06b709084f8e2e4114baadf2e"},
+	} {
+		eng.Reset()
+		eng.PushStack([]byte(tc.in))
+		b, err := eng.RunProgram(p)
+		if err != nil {
+			t.Errorf("%s: %s", tc.in, err)
+		} else if string(b) != tc.out {
+			t.Errorf("%s: expected %s, got %s", tc.in, tc.out, b)
+		}
+	}
+
+	// stack depth is checked before running
+	eng.Reset()
+	if _, err = eng.RunProgram(p); err == nil || !strings.Contains(err.Error(), "needs 1 values") {
+		t.Errorf("expected a stack depth error, got %v", err)
+	}
+	eng.Reset()
+	eng.PushStack([]byte("A"))
+	eng.PushStack([]byte("B"))
+	if _, err = eng.RunProgram(p); err == nil || !strings.Contains(err.Error(), "leave 2 values") {
+		t.Errorf("expected a leftover values error, got %v", err)
+	}
+
+	// invalid literals fail at compile time
+	if _, err = Compile([]string{"hex:0"}); err == nil {
+		t.Error("expected an error for an odd length hex literal")
+	}
+}
+
+func TestCompileMacro(t *testing.T) {
+	p, err := Compile([]string{"hash-hmac-md5", "call", "hex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := New()
+	eng.PushStack([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	b, err := eng.RunProgram(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "7b9421e56f37e2bbce23a96fb71c02d2" {
+		t.Errorf("unexpected result %s", b)
+	}
+
+	// a replaced macro is honored even though it was expanded
+	eng.Reset()
+	eng.SetVariable("hash-hmac-md5", []byte("/md5"))
+	eng.PushStack([]byte("Hello"))
+	b, err = eng.RunProgram(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "8b1a9953c4611296a827abf8c47804d7" {
+		t.Errorf("unexpected result %s", b)
+	}
+
+	// the stack check uses the replaced macro's effect, not the default one
+	eng.Reset()
+	eng.SetVariable("hash-hmac-md5", []byte("/'Hello/md5"))
+	if b, err = eng.RunProgram(p); err != nil {
+		t.Errorf("expected the replaced macro to run on an empty stack, got %v", err)
+	} else if string(b) != "8b1a9953c4611296a827abf8c47804d7" {
+		t.Errorf("unexpected result %s", b)
+	}
+	p, err = Compile([]string{"encrypt-sign-aes", "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng.Reset()
+	eng.SetVariable("encrypt-aes", []byte("/'Hello"))
+	if _, err = eng.RunProgram(p); err != nil {
+		t.Errorf("expected a macro within a macro to be replaceable, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var limitCases = []struct {
+		name     string
+		limits   Limits
+		vars     map[string]string
+		commands []string
+		err      error
+	}{
+		{name: "call depth", limits: Limits{MaxCallDepth: 10}, vars: map[string]string{"x": "/x/call"}, commands: []string{"x", "call"}, err: ErrCallDepth},
+		{name: "stack items", limits: Limits{MaxStackItems: 2}, commands: []string{"A", "B", "C", "append", "append"}, err: ErrStackItems},
+		{name: "rand", limits: Limits{MaxValueBytes: 1000}, commands: []string{"2000000000", "rand"}, err: ErrValueBytes},
+		{name: "total bytes", limits: Limits{MaxTotalBytes: 100}, commands: []string{"60", "rand", "60", "rand", "append"}, err: ErrTotalBytes},
+		{name: "saved bytes", limits: Limits{MaxTotalBytes: 100}, commands: []string{"60", "rand", "a", "save", "60", "rand", "b", "save", "x"}, err: ErrTotalBytes},
+		{name: "literal", limits: Limits{MaxValueBytes: 10}, commands: []string{"'more than ten bytes"}, err: ErrValueBytes},
+		{name: "ops", limits: Limits{MaxOps: 3}, commands: []string{"A", "B", "append", "hex"}, err: ErrOps},
+		{name: "timeout", limits: Limits{Timeout: time.Nanosecond}, commands: []string{"A", "hex"}, err: ErrTimeout},
+		{name: "ungzip", limits: Limits{MaxValueBytes: 1000}, commands: []string{"hex:1f8b0800000000000203edc1010d000000c2a0f74f6d0e37a0000000000000000000e0df002eca3b4d10270000", "ungzip"}, err: ErrValueBytes},
+		{name: "foreach results", limits: Limits{MaxTotalBytes: 500, MaxOps: 250}, vars: map[string]string{"r": "/pop/60/rand"}, commands: []string{"'" + strings.Repeat("x\n", 100), "r", "foreach"}, err: ErrTotalBytes},
+		{name: "try", limits: Limits{MaxCallDepth: 10}, vars: map[string]string{"x": "/x/call", "h": "/OK"}, commands: []string{"x", "h", "try"}, err: ErrCallDepth},
+	}
+
+	eng := New()
+	for _, tc := range limitCases {
+		eng.Reset()
+		eng.Limits = tc.limits
+		for k, v := range tc.vars {
+			eng.SetVariable(k, []byte(v))
+		}
+		_, err := eng.Run(tc.commands)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("test case %q: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	// try doesn't catch a cancelled run
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eng.Reset()
+	eng.Limits = Limits{}
+	eng.Register("test-cancel", FuncSpec{In: "", Out: "", Fn: func(s *Stack, e *Engine) error {
+		cancel()
+		return nil
+	}})
+	eng.SetVariable("x", []byte("/test-cancel/A"))
+	eng.SetVariable("h", []byte("/OK"))
+	p, err := Compile([]string{"x", "h", "try"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = eng.RunProgramContext(ctx, p); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if v := eng.GetVariable("error"); v != nil {
+		t.Errorf("expected the handler not to be started, but error was set to %q", v)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var (
+		underflow *StackUnderflowError
+		decode    *DecodeError
+		invalid   *InvalidArgumentError
+		verify    *VerificationFailedError
+		unused    *UnusedValuesError
+	)
+	var errorCases = []struct {
+		name     string
+		commands []string
+		target   any
+		op       string
+		index    int
+		depth    int
+	}{
+		{name: "swap", commands: []string{"A", "'nop", "call", "swap"}, target: &underflow, op: "swap", index: 3, depth: 1},
+		{name: "pick", commands: []string{"A", "5", "pick"}, target: &underflow, op: "pick", index: 2, depth: 2},
+		{name: "unhex", commands: []string{"zz", "unhex"}, target: &decode, op: "unhex", index: 1, depth: 1},
+		{name: "add", commands: []string{"1", "x", "add"}, target: &decode, op: "add", index: 2, depth: 2},
+		{name: "key size", commands: []string{"A", "hex:00000000000000000000000000000000", "hex:00", "aes-cfb"}, target: &invalid, op: "aes-cfb", index: 3, depth: 3},
+		{name: "iv size", commands: []string{"A", "hex:00", "hex:00000000000000000000000000000000", "aes-cfb"}, target: &invalid, op: "aes-cfb", index: 3, depth: 3},
+		{name: "eq", commands: []string{"A", "B", "eq", "C"}, target: &verify, op: "eq", index: 2, depth: 2},
+		{name: "mac key size", commands: []string{"A", "hex:" + strings.Repeat("00", 33), "mac-blake2s-256"}, target: &invalid, op: "mac-blake2s-256", index: 2, depth: 2},
+		{name: "shake length", commands: []string{"A", "-1", "shake128"}, target: &invalid, op: "shake128", index: 2, depth: 2},
+		{name: "call", commands: []string{"Hello", "checksig-sha256", "call"}, target: &verify, op: "checksig-sha256", index: 1, depth: 1},
+	}
+
+	eng := New()
+	for _, tc := range errorCases {
+		eng.Reset()
+		_, err := eng.Run(tc.commands)
+		if !errors.As(err, tc.target) {
+			t.Errorf("test case %q: expected %T, got %v", tc.name, tc.target, err)
+			continue
+		}
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			t.Errorf("test case %q: expected an OpError, got %v", tc.name, err)
+			continue
+		}
+		if oe.Op != tc.op || oe.Index != tc.index || oe.StackDepth != tc.depth {
+			t.Errorf("test case %q: expected %s at %d with depth %d, got %s at %d with depth %d",
+				tc.name, tc.op, tc.index, tc.depth, oe.Op, oe.Index, oe.StackDepth)
+		}
+	}
+
+	// errors found before running are typed too
+	for _, commands := range [][]string{{"swap"}, {"A", "B"}} {
+		eng.Reset()
+		_, err := eng.Run(commands)
+		if !errors.As(err, &underflow) && !errors.As(err, &unused) {
+			t.Errorf("%v: expected a stack error, got %v", commands, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	eng := New()
+	eng.PushStack([]byte("Hello"))
+	_, err := eng.RunContext(ctx, []string{"sha256", "hex"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// the engine is usable again afterward
+	eng.Reset()
+	eng.PushStack([]byte("Hello"))
+	_, err = eng.Run([]string{"sha256", "hex"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	err := Register("test-twice", FuncSpec{In: "Data", Out: "Data", Desc: "Repeats the data", Fn: func(s *Stack, e *Engine) error {
+		b := s.Pop()
+		if b == nil {
+			return errors.New("test-twice: expected 1 value on the stack")
+		}
+		s.Push(append(append([]byte{}, b...), b...))
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Register("test-twice", FuncSpec{Fn: func(s *Stack, e *Engine) error { return nil }}); err == nil {
+		t.Error("expected an error registering the same name twice")
+	}
+	if err = Register("md5", FuncSpec{Fn: func(s *Stack, e *Engine) error { return nil }}); err == nil {
+		t.Error("expected an error registering a built-in name")
+	}
+	if err = Register("hex:00", FuncSpec{Fn: func(s *Stack, e *Engine) error { return nil }}); err == nil {
+		t.Error("expected an error registering a literal")
+	}
+
+	eng := New()
+	err = eng.Register("test-lower", FuncSpec{In: "Data", Out: "Data", Desc: "Lower cases the data", Fn: func(s *Stack, e *Engine) error {
+		s.Push(bytes.ToLower(s.Pop()))
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eng.Reset()
+	eng.SetVariable("twice-lower", []byte("/test-twice/test-lower"))
+	eng.PushStack([]byte("AB"))
+	b, err := eng.Run([]string{"twice-lower", "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "abab" {
+		t.Errorf("unexpected result %s", b)
+	}
+	if !bytes.Contains(eng.Help(), []byte("Lower cases the data")) {
+		t.Error("expected the registered function in the help")
+	}
+
+	// other engines do not see the function
+	other := New()
+	other.PushStack([]byte("AB"))
+	if _, err = other.Run([]string{"test-lower"}); err == nil {
+		t.Error("expected an error using a function registered with another engine")
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New()
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	b.ReportAllocs()
+	eng := New()
+	for i := 0; i < b.N; i++ {
+		eng.SetVariable("body", []byte("Hello"))
+		eng.Reset()
+	}
+}
+
+func BenchmarkRunNew(b *testing.B) {
+	b.ReportAllocs()
+	p, err := Compile([]string{"sha256", "hex"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		eng := New()
+		eng.PushStack([]byte("Hello"))
+		if _, err := eng.RunProgram(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRunPooled(b *testing.B) {
+	b.ReportAllocs()
+	p, err := Compile([]string{"sha256", "hex"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool := sync.Pool{New: func() any { return New() }}
+	for i := 0; i < b.N; i++ {
+		eng := pool.Get().(*Engine)
+		eng.PushStack([]byte("Hello"))
+		if _, err := eng.RunProgram(p); err != nil {
+			b.Fatal(err)
+		}
+		eng.Reset()
+		pool.Put(eng)
+	}
+}
+
+func TestStackErrors(t *testing.T) {
+	for _, commands := range [][]string{
+		{"A", "over"},
+		{"A", "B", "rot"},
+		{"A", "B", "-rot"},
+		{"A", "nip"},
+		{"A", "tuck"},
+		{"A", "1", "pick"},
+		{"A", "-1", "pick"},
+		{"A", "B", "2", "roll"},
+		{"A", "2dup"},
+		{"A", "B", "C", "2swap"},
+	} {
+		eng := New()
+		if _, err := eng.Run(commands); err == nil {
+			t.Errorf("expected an error for %v", commands)
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	for _, commands := range [][]string{
+		{"A", "1", "add"},
+		{"1", "add"},
+		{"1.5", "1", "add"},
+		{"9223372036854775807", "inc"},
+		{"-9223372036854775808", "dec"},
+		{"9223372036854775807", "2", "mul"},
+		{"-9223372036854775808", "-1", "div"},
+		{"1", "0", "div"},
+		{"1", "0", "mod"},
+	} {
+		eng := New()
+		if _, err := eng.Run(commands); err == nil {
+			t.Errorf("expected an error for %v", commands)
+		}
+	}
+}
+
+func TestBitwiseErrors(t *testing.T) {
+	for _, commands := range [][]string{
+		{"AB", "A", "xor"},
+		{"A", "and"},
+		{"AB", "-1", "repeat"},
+		{"AB", "4", "00", "pad-left"},
+		{"-1", "zeros"},
+		{"AB", "2", "byte-at"},
+	} {
+		eng := New()
+		if _, err := eng.Run(commands); err == nil {
+			t.Errorf("expected an error for %v", commands)
+		}
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	for _, commands := range [][]string{
+		{"abc", "'", "x", "replace"},
+		{"abc", "(", "regex-match"},
+		{"abc", strings.Repeat("a", 2000), "regex-match"},
+		{"abc", "'", "split"},
+		{"a", "2", ",", "join"},
+	} {
+		eng := New()
+		if _, err := eng.Run(commands); err == nil {
+			t.Errorf("expected an error for %v", commands)
+		}
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	eng := New()
+	eng.PushStack([]byte("ABC"))
+	if _, err := eng.Run([]string{"sign-sha256", "call", "X", "append", "checksig-sha256", "call"}); err == nil {
+		t.Error("expected tampered data to fail the signature check")
+	}
+	if !strings.Contains(string(defaultValues["checksig-sha256"]), "/ct-eq") {
+		t.Error("expected checksig-sha256 to use ct-eq")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	eng := New()
+	var ro *ReadOnlyError
+	if err := eng.SetUserVariable("Encrypt-AES", []byte("/md5")); !errors.As(err, &ro) {
+		t.Errorf("expected a read-only error for a built-in program, got %v", err)
+	}
+	if err := eng.SetUserVariable("key", []byte("my key")); err != nil {
+		t.Errorf("expected the default key to be replaceable, got %v", err)
+	}
+	eng.SetReadOnlyVariable("key", []byte("server key"))
+	if err := eng.SetUserVariable("key", []byte("my key")); !errors.As(err, &ro) {
+		t.Errorf("expected a read-only error for a protected key, got %v", err)
+	}
+	if string(eng.GetVariable("key")) != "server key" {
+		t.Errorf("unexpected key %q", eng.GetVariable("key"))
+	}
+
+	// save cannot change protected variables either
+	eng.PushStack([]byte("ABC"))
+	if _, err := eng.Run([]string{"push", "key", "save"}); !errors.As(err, &ro) {
+		t.Errorf("expected a read-only error from save, got %v", err)
+	}
+
+	// nor can try when it records an error
+	eng.Reset()
+	eng.SetReadOnlyVariable("error", []byte("server error"))
+	eng.SetVariable("bad", []byte("/zz/unhex"))
+	eng.SetVariable("h", []byte("/error/load"))
+	if _, err := eng.Run([]string{"bad", "h", "try"}); !errors.As(err, &ro) {
+		t.Errorf("expected a read-only error from try, got %v", err)
+	}
+	if string(eng.GetVariable("error")) != "server error" {
+		t.Errorf("unexpected error variable %q", eng.GetVariable("error"))
+	}
+
+	// Reset removes the protection
+	eng.Reset()
+	if err := eng.SetUserVariable("key", []byte("my key")); err != nil {
+		t.Errorf("expected the key to be replaceable after Reset, got %v", err)
+	}
+}
+
+func TestNoDefaultKey(t *testing.T) {
+	eng := New()
+	eng.NoDefaultKey = true
+	eng.PushStack([]byte("TheData"))
+	if _, err := eng.Run([]string{"key", "load", "hmac-sha256"}); !errors.Is(err, ErrDefaultKey) {
+		t.Errorf("expected the default key to be refused, got %v", err)
+	}
+
+	eng.Reset()
+	eng.NoDefaultKey = true
+	eng.SetReadOnlyVariable("key", []byte("server key"))
+	eng.PushStack([]byte("TheData"))
+	if _, err := eng.Run([]string{"key", "load", "hmac-sha256"}); err != nil {
+		t.Errorf("expected a configured key to work, got %v", err)
+	}
+}
+
+func TestSecretVariable(t *testing.T) {
+	eng := New()
+	eng.SetSecretVariable("Signing-Key", []byte("s3cret"))
+	eng.SetReadOnlyVariable("plain", []byte("visible"))
+	if h := eng.Help(); bytes.Contains(h, []byte("s3cret")) || !bytes.Contains(h, []byte("visible")) {
+		t.Errorf("expected the help to leave out secrets")
+	}
+	if err := eng.SetUserVariable("signing-key", []byte("x")); err == nil {
+		t.Error("expected a secret to be read-only")
+	}
+	if !eng.IsSecret("SIGNING-KEY") || eng.IsSecret("plain") {
+		t.Error("unexpected secret flags")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	k1 := strings.Repeat("11", 16)
+	k2 := strings.Repeat("22", 32)
+
+	eng := New()
+	eng.Keyring = NewKeyring()
+	if err := eng.Keyring.LoadSpec("k1:active:" + k1); err != nil {
+		t.Fatal(err)
+	}
+	eng.PushStack([]byte("TheData"))
+	old, err := eng.Run([]string{"protect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate using a folder
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "k1.decrypt"), []byte(k1+"\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "k2.active"), []byte(k2), 0600)
+	eng.Reset()
+	eng.Keyring = NewKeyring()
+	if err = eng.Keyring.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	eng.PushStack(old)
+	b, err := eng.Run([]string{"unprotect"})
+	if err != nil || string(b) != "TheData" {
+		t.Errorf("expected old data to unprotect, got %q, %v", b, err)
+	}
+	eng.PushStack([]byte("TheData"))
+	b, err = eng.Run([]string{"protect"})
+	if err != nil || !bytes.Contains(b[:8], []byte("k2")) {
+		t.Errorf("expected data protected with k2, got %x, %v", b, err)
+	}
+
+	// changing the header or the data fails
+	for _, i := range []int{3, len(old) - 1} {
+		bad := bytes.Clone(old)
+		bad[i] ^= 1
+		eng.PushStack(bad)
+		if _, err = eng.Run([]string{"unprotect"}); err == nil {
+			t.Errorf("expected changed byte %d to fail", i)
+		}
+	}
+
+	// retired keys cannot be used
+	eng.Keyring = NewKeyring()
+	eng.Keyring.LoadSpec("k1:retired:" + k1 + ",k2:active:" + k2)
+	eng.PushStack(old)
+	if _, err = eng.Run([]string{"unprotect"}); err == nil || !strings.Contains(err.Error(), "retired") {
+		t.Errorf("expected a retired key error, got %v", err)
+	}
+
+	eng.Keyring = nil
+	eng.PushStack(old)
+	if _, err = eng.Run([]string{"unprotect"}); !errors.Is(err, ErrNoKeyring) {
+		t.Errorf("expected ErrNoKeyring, got %v", err)
+	}
+
+	for _, spec := range []string{"k1:active:" + k1 + ",k2:active:" + k2, "k1:active:1234", "k1:used:" + k1, "k1:" + k1} {
+		if err = NewKeyring().LoadSpec(spec); err == nil {
+			t.Errorf("expected %q to fail", spec)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	eng := New()
+	eng.DebugMode = true
+	eng.SetSecretVariable("api", []byte("s3cret"))
+	eng.SetVariable("token", []byte("t0ken"))
+	eng.MarkSecret("token")
+	eng.SetVariable("plain", []byte("visible"))
+	eng.Keyring = NewKeyring()
+	eng.Keyring.LoadSpec("k1:active:" + strings.Repeat("11", 16))
+	eng.PushStack([]byte("pl41ntext"))
+	b, err := eng.Run([]string{"protect", "unprotect", "api", "load", "token", "load", "key", "load", "plain", "load", "'nop", "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng.LogValues()
+	out := b
+	for _, s := range []string{"s3cret", "t0ken", defaultKey, "73 33 63 72 65 74"} {
+		if bytes.Contains(out, []byte(s)) {
+			t.Errorf("debug output shows secret %q", s)
+		}
+	}
+	// the input is shown before protect, but not after unprotect
+	if n := bytes.Count(out, []byte("pl41ntext")); n != 1 {
+		t.Errorf("expected unprotected data to be redacted, found it %d times", n)
+	}
+	if !bytes.Contains(out, []byte("visible")) || !bytes.Contains(out, []byte("[secret: 6 bytes, sha256 ")) {
+		t.Errorf("unexpected debug output %s", out)
+	}
+	if h := eng.Help(); bytes.Contains(h, []byte("s3cret")) || !bytes.Contains(h, []byte("visible")) {
+		t.Errorf("expected the help to redact secrets")
+	}
+	if eng.IsSecret("plain") || !eng.IsSecret("KEY") {
+		t.Error("unexpected secret flags")
+	}
+	eng.Reset()
+	if eng.IsSecret("api") {
+		t.Error("expected Reset to clear secrets")
+	}
+}
+
+func TestRedactDerived(t *testing.T) {
+	key := []byte("server key")
+	sum := md5.Sum(key)
+	hash := sha256.Sum256(key)
+	eng := New()
+	eng.SetSecretVariable("key", key)
+	eng.PushStack([]byte("pl41ntext"))
+	ciphertext, err := eng.Run([]string{"encrypt-aes", "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the derived key and the decrypted data are redacted
+	for _, tc := range []struct {
+		commands []string
+		input    []byte
+		hidden   []string
+	}{
+		{[]string{"encrypt-aes", "call"}, []byte("data"), []string{fmt.Sprintf("% x", sum), fmt.Sprintf("%+q", sum)}},
+		{[]string{"decrypt-aes", "call"}, ciphertext, []string{"pl41ntext", fmt.Sprintf("% x", sum)}},
+		{[]string{"key", "load", "sha256", "x", "save"}, []byte("data"), []string{fmt.Sprintf("% x", hash[:8])}},
+	} {
+		eng.Reset()
+		eng.DebugMode = true
+		eng.Tracer = new(JSONTracer)
+		eng.SetSecretVariable("key", key)
+		eng.PushStack(tc.input)
+		b, err := eng.Run(tc.commands)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tc.hidden {
+			if bytes.Contains(b, []byte(s)) {
+				t.Errorf("%v: trace shows %q", tc.commands, s)
+			}
+		}
+		if !bytes.Contains(b, []byte("[secret: ")) {
+			t.Errorf("%v: expected redacted values", tc.commands)
+		}
+	}
+}
+
+type countTracer struct {
+	steps, messages int
+}
+
+func (c *countTracer) Step(s *Step)        { c.steps++ }
+func (c *countTracer) Message(text string) { c.messages++ }
+
+func TestTrace(t *testing.T) {
+	// a tracer watches without changing the result
+	eng := New()
+	var c countTracer
+	eng.Tracer = &c
+	eng.PushStack([]byte("TheData"))
+	b, err := eng.Run([]string{"sha256", "hex"})
+	if err != nil || len(b) != 64 {
+		t.Fatalf("unexpected result %q, %v", b, err)
+	}
+	if c.steps != 2 || c.messages == 0 {
+		t.Errorf("unexpected trace with %d steps and %d messages", c.steps, c.messages)
+	}
+
+	// in debug mode, the JSON tracer builds the output
+	eng.Reset()
+	eng.DebugMode = true
+	j := new(JSONTracer)
+	eng.Tracer = j
+	eng.PushStack([]byte("TheData"))
+	b, err = eng.Run([]string{"push", "x", "save", "'abc", "md5", "swap", "pop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Trace  []TraceEntry
+		Result *TraceValue
+		Error  string
+	}
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b)
+	}
+	if out.Error != "" || out.Result == nil || out.Result.Length != 16 {
+		t.Errorf("unexpected result %+v, error %q", out.Result, out.Error)
+	}
+	var steps []*Step
+	for _, e := range j.Entries {
+		if e.Step != nil {
+			steps = append(steps, e.Step)
+		}
+	}
+	if len(steps) != 7 {
+		t.Fatalf("expected 7 steps, got %d", len(steps))
+	}
+	if s := steps[2]; s.Op != "save" || len(s.Vars) != 1 || s.Vars[0].Name != "x" || s.Vars[0].Text != `"TheData"` {
+		t.Errorf("expected save to record the variable change, got %+v", s)
+	}
+	if s := steps[3]; s.Kind != "push" || len(s.Before) != 1 || len(s.After) != 2 || s.After[1].Hex != "61 62 63" {
+		t.Errorf("unexpected push step %+v", s)
+	}
+
+	// errors are recorded on the failing step
+	eng.Reset()
+	j = new(JSONTracer)
+	eng.Tracer = j
+	eng.PushStack([]byte("zz"))
+	if _, err = eng.Run([]string{"unhex"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if last := j.Entries[len(j.Entries)-1]; last.Step == nil || last.Step.Err == nil || last.Error == "" {
+		t.Errorf("expected the last step to have the error, got %+v", last)
+	}
+	if eng.Reset(); eng.Tracer != nil {
+		t.Error("expected Reset to remove the tracer")
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	p, err := Compile([]string{"sha256", "twice", "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := New()
+	eng.SetVariable("twice", []byte("/sha256/sha256"))
+	eng.PushStack([]byte("TheData"))
+	b, stats, err := eng.RunProgramStats(context.Background(), p)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("unexpected result %x, %v", b, err)
+	}
+	want := []OpStats{
+		{Op: "sha256", Depth: 1, BytesIn: 7, BytesOut: 32},
+		{Op: "twice", Depth: 1, BytesIn: 0, BytesOut: 5},
+		{Op: "call", Depth: 1, BytesIn: 37, BytesOut: 32},
+		{Op: "sha256", Depth: 2, BytesIn: 32, BytesOut: 32},
+		{Op: "sha256", Depth: 2, BytesIn: 32, BytesOut: 32},
+	}
+	if len(stats.Ops) != len(want) {
+		t.Fatalf("expected %d ops, got %+v", len(want), stats.Ops)
+	}
+	for i, w := range want {
+		got := stats.Ops[i]
+		got.Duration = 0
+		if got != w {
+			t.Errorf("op %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+	var sha OpSummary
+	for _, s := range stats.Summary {
+		if s.Op == "sha256" {
+			sha = s
+		}
+	}
+	if len(stats.Summary) != 3 || sha.Count != 3 || sha.BytesIn != 71 || stats.Duration <= 0 {
+		t.Errorf("unexpected summary %+v", stats.Summary)
+	}
+
+	// a failed run is profiled up to the failure
+	eng.Reset()
+	eng.SetVariable("twice", []byte("/sha256/unhex"))
+	eng.PushStack([]byte("TheData"))
+	_, stats, err = eng.RunProgramStats(context.Background(), p)
+	if err == nil || len(stats.Ops) != 5 || len(stats.Summary) != 4 || stats.Duration <= 0 {
+		t.Errorf("unexpected profile of a failed run %+v, %v", stats, err)
+	}
+	eng.Reset()
+	eng.PushStack([]byte("TheData"))
+	v, stats, err := eng.RunValueStats(context.Background(), p)
+	if err == nil || v != nil || stats.Duration <= 0 {
+		t.Errorf("unexpected profile %+v, %v", stats, err)
+	}
+	eng.Reset()
+	eng.PushStack([]byte("TheData"))
+	b, stats, err = eng.RunContextStats(context.Background(), []string{"md5", "hex"})
+	if err != nil || len(b) != 32 || len(stats.Ops) != 2 || stats.Duration <= 0 {
+		t.Errorf("unexpected profile %+v, %v", stats, err)
+	}
+
+	// debug mode shows the summary
+	eng.Reset()
+	eng.DebugMode = true
+	eng.PushStack([]byte("TheData"))
+	b, err = eng.RunProgram(p)
+	if err != nil || !bytes.Contains(b, []byte("<th>Bytes In</th>")) {
+		t.Errorf("expected a summary in the debug output, got %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	eng := New()
+	eng.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("request", "r1")
+	eng.SetSecretVariable("api", []byte("s3cret"))
+	eng.PushStack([]byte("TheData"))
+	if _, err := eng.Run([]string{"sha256", "hex"}); err != nil {
+		t.Fatal(err)
+	}
+	eng.LogValues()
+	out := buf.String()
+	for _, s := range []string{"request=r1", "msg=op request=r1 op=sha256 depth=1", "bytesIn=7 bytesOut=32", "name=api"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in the log:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Error("the log shows a secret")
+	}
+
+	// nothing is logged above the debug level
+	buf.Reset()
+	eng.Reset()
+	eng.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	eng.PushStack([]byte("TheData"))
+	if _, err := eng.Run([]string{"sha256"}); err != nil || buf.Len() != 0 {
+		t.Errorf("expected no log output, got %q, %v", buf.String(), err)
+	}
+}
+
+func TestExamples(t *testing.T) {
+	for _, doc := range New().Catalog() {
+		// functions registered by other tests have no category
+		if doc.Category == "Other" && !strings.HasPrefix(doc.Name, "test-") {
+			t.Errorf("%s has no category", doc.Name)
+		}
+		for _, ex := range doc.Examples {
+			eng := New()
+			if ex.Input != "" {
+				eng.PushStack([]byte(ex.Input))
+			}
+			var commands []string
+			for _, c := range strings.Split(strings.TrimPrefix(ex.Pipeline, "/"), "/") {
+				c, err := url.PathUnescape(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				commands = append(commands, c)
+			}
+			b, err := eng.Run(commands)
+			if err != nil || string(b) != ex.Output {
+				t.Errorf("%s: %s returned %q, %v; expected %q", doc.Name, ex.Pipeline, b, err, ex.Output)
+			}
+		}
+	}
+
+	eng := New()
+	eng.Register("test-example", FuncSpec{In: "Data", Out: "Data", Desc: "Does nothing", Examples: []Example{{Pipeline: "/test-example", Input: "A", Output: "A"}},
+		Fn: func(s *Stack, e *Engine) error { return nil }})
+	doc, ok := eng.Describe("test-example")
+	if !ok || doc.Category != "Other" || len(doc.Examples) != 1 {
+		t.Errorf("unexpected description %+v", doc)
+	}
+	if page := eng.HelpFunc("test-example"); !bytes.Contains(page, []byte("Does nothing")) {
+		t.Error("expected the description on the help page")
+	}
+	if eng.HelpFunc("no-such-function") != nil {
+		t.Error("expected no help page for an unknown function")
+	}
+}
+
+func TestStream(t *testing.T) {
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	key := "hex:000102030405060708090a0b0c0d0e0f"
+	iv := "hex:0f0e0d0c0b0a09080706050403020100"
+
+	var streamCases = []string{
+		"/sha256",
+		"/sha256/hex",
+		"/md5/hex",
+		"/crc32-castagnoli/hex",
+		"/sha3-512",
+		"/blake2s-256/hex",
+		"/MyKey/hmac-sha512-256",
+		"/MyKey/mac-blake2b-384",
+		"/100/shake256",
+		"/MyKey/hmac-sha512/base64",
+		"/hash-hmac-sha256/call",
+		"/hex",
+		"/base64/unbase64",
+		"/9/gzip/ungzip/sha1/hex",
+		"/zlib/unzlib",
+		"/-1/deflate/inflate",
+		"/5/gzip",
+		"/" + iv + "/" + key + "/aes-ctr",
+		"/" + iv + "/" + key + "/aes-cfb/" + iv + "/" + key + "/unaes-cfb",
+		"/hex:0001020304050607/" + key + "/blowfish-ofb/base32",
+		"/hex:0001020304050607/" + key + "/salt/blowfish-salt-cfb",
+		"/A/hex/pop/sha256",
+		"/sha256/'x/append",
+	}
+	eng := New()
+	for _, pipeline := range streamCases {
+		p, err := Compile(strings.Split(pipeline[1:], "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !eng.Streamable(p) {
+			t.Errorf("%s: expected the pipeline to be streamable", pipeline)
+			continue
+		}
+		eng.Reset()
+		eng.PushStack(data)
+		want, err := eng.RunProgram(p)
+		if err != nil {
+			t.Fatalf("%s: %v", pipeline, err)
+		}
+		eng.Reset()
+		var got bytes.Buffer
+		if err = eng.RunStream(context.Background(), p, bytes.NewReader(data), &got); err != nil {
+			t.Errorf("%s: %v", pipeline, err)
+		} else if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: streamed result differs", pipeline)
+		}
+	}
+
+	var bufferedCases = []string{
+		"/push/append",
+		"/snappy",
+		"/len",
+		"/A/append",
+		"/A/swap/hmac-sha256",
+		"/sign-sha256/call",
+		"/A",
+		"/",
+		"/twice",
+		"/test-stream",
+	}
+	if err := eng.Register("test-stream", FuncSpec{Fn: func(s *Stack, e *Engine) error { return nil }, In: "Data", Out: "Data"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pipeline := range bufferedCases {
+		p, err := Compile(parseCommands(pipeline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eng.Streamable(p) {
+			t.Errorf("%s: expected the pipeline not to be streamable", pipeline)
+		}
+		if err = eng.RunStream(context.Background(), p, bytes.NewReader(data), io.Discard); !errors.Is(err, ErrNotStreamable) {
+			t.Errorf("%s: expected %v, got %v", pipeline, ErrNotStreamable, err)
+		}
+	}
+
+	// errors in the stream are typed, and name the command that failed
+	p, _ := Compile([]string{"base64", "unbase64", "unhex", "9", "gzip", "sha256"})
+	eng.Reset()
+	err := eng.RunStream(context.Background(), p, strings.NewReader("zz"), io.Discard)
+	var de *DecodeError
+	var oe *OpError
+	if !errors.As(err, &de) || !errors.As(err, &oe) || oe.Op != "unhex" || oe.Index != 2 {
+		t.Errorf("expected a DecodeError from unhex, got %v", err)
+	}
+	p, _ = Compile([]string{"A", "aes-ctr"})
+	eng.Reset()
+	err = eng.RunStream(context.Background(), p, strings.NewReader("zz"), io.Discard)
+	var ue *StackUnderflowError
+	if eng.Streamable(p) || !errors.As(err, &ue) && !errors.Is(err, ErrNotStreamable) {
+		t.Errorf("expected the pipeline to be rejected, got %v", err)
+	}
+
+	// a cancelled run stops reading
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, _ = Compile([]string{"9", "gzip", "sha256"})
+	eng.Reset()
+	if err = eng.RunStream(ctx, p, bytes.NewReader(data), io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestLoads(t *testing.T) {
+	for _, tc := range []struct {
+		pipeline string
+		loads    bool
+	}{
+		{"/sha256/hex", false},
+		{"/body/load", true},
+		{"/'BODY/load/append", true},
+		{"/hex:626f6479/load", true},
+		{"/key/load/hmac-sha256", false},
+		{"/'somebody/pop/sha256", false},
+		{"/bo/dy/append/load", true},
+		{"/load", true},
+		{"/hash-hmac-sha256/call", false},
+	} {
+		p, err := Compile(parseCommands(tc.pipeline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loads := p.Loads("body"); loads != tc.loads {
+			t.Errorf("%s: expected %v, got %v", tc.pipeline, tc.loads, loads)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	for _, tc := range []struct {
+		pipeline string
+		redacted string
+	}{
+		{"/sha256/hex", "/sha256/hex"},
+		{"/MyKey/hmac-sha256", "/*/hmac-sha256"},
+		{"/hex:00112233/'secret/b64:AAAA/append/append", "/*/*/*/append/append"},
+		{"/hash-hmac-sha256/call/hex", "/hash-hmac-sha256/call/hex"},
+		{"/", "/"},
+	} {
+		p, err := Compile(parseCommands(tc.pipeline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Redacted(); got != tc.redacted {
+			t.Errorf("%s: expected %s, got %s", tc.pipeline, tc.redacted, got)
+		}
+	}
+}
+
+// TestStreamMatches runs every streamable pipeline made from the catalog,
+// with and without commands that look at the stack, both buffered and
+// streamed, and checks that the results are the same
+func TestStreamMatches(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	key := "hex:000102030405060708090a0b0c0d0e0f"
+	iv := "hex:0f0e0d0c0b0a09080706050403020100"
+	args := []string{"", "/MyKey", "/32", "/9", "/" + key, "/" + iv + "/" + key, "/hex:0001020304050607/" + key}
+	wrap := []string{"%s", "/depth/'1/eq%s", "/depth/pop%s", "/A/depth/'2/eq/pop%s", "%s/depth/append", "%s/depth/'1/eq"}
+
+	type run struct {
+		pipeline string
+		input    []byte
+	}
+	var runs []run
+	eng := New()
+	for _, doc := range eng.Catalog() {
+		for _, ex := range doc.Examples {
+			runs = append(runs, run{ex.Pipeline, []byte(ex.Input)})
+		}
+		for _, a := range args {
+			for _, w := range wrap {
+				runs = append(runs, run{fmt.Sprintf(w, a+"/"+doc.Name), data})
+			}
+		}
+	}
+
+	streamed := 0
+	for _, r := range runs {
+		var commands []string
+		for _, c := range parseCommands(r.pipeline) {
+			c, err := url.PathUnescape(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commands = append(commands, c)
+		}
+		p, err := Compile(commands)
+		if err != nil || !eng.Streamable(p) {
+			continue
+		}
+		streamed++
+		eng.Reset()
+		eng.PushStack(r.input)
+		want, wantErr := eng.RunProgram(p)
+		eng.Reset()
+		var got bytes.Buffer
+		err = eng.RunStream(context.Background(), p, bytes.NewReader(r.input), &got)
+		switch {
+		case (err == nil) != (wantErr == nil):
+			t.Errorf("%s: buffered run returned %v, streamed run returned %v", r.pipeline, wantErr, err)
+		case err == nil && !bytes.Equal(got.Bytes(), want):
+			t.Errorf("%s: streamed result differs", r.pipeline)
+		}
+	}
+	if streamed < 100 {
+		t.Errorf("expected more streamable pipelines, found %d", streamed)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	key := "hex:000102030405060708090a0b0c0d0e0f"
+	iv := "hex:0f0e0d0c0b0a09080706050403020100"
+	dir := t.TempDir()
+	files := func() int {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	var cases = []string{
+		"/sha256/hex",
+		"/MyKey/hmac-sha512/base64",
+		"/hash-hmac-sha256/call",
+		"/push/sha256/swap/md5/append/hex",
+		"/hex/unhex/sha1",
+		"/MyKey/mac-blake2b-512/hex",
+		"/64/shake128",
+		"/9/gzip/ungzip",
+		"/" + iv + "/" + key + "/aes-ctr/" + iv + "/" + key + "/aes-ctr",
+		"/reverse/sha256",
+		"/len/swap/pop",
+		"/A/swap/2dup/nip/nip/tuck/pop/pop",
+		"/x/save/x/load/sha256",
+		"/body/load/sha256/swap/pop",
+		"/10/left",
+	}
+	mem := New()
+	eng := New()
+	eng.SpillBytes = 1024
+	eng.TempDir = dir
+	for _, pipeline := range cases {
+		p, err := Compile(parseCommands(pipeline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mem.Reset()
+		mem.PushStack(data)
+		mem.SetReadOnlyVariable("body", data)
+		want, err := mem.RunProgram(p)
+		if err != nil {
+			t.Fatalf("%s: %v", pipeline, err)
+		}
+
+		eng.Reset()
+		v, err := eng.ReadValue(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := v.(*fileValue); !ok || files() != 1 {
+			t.Fatalf("expected the value to be kept in a temporary file")
+		}
+		eng.PushValue(v)
+		eng.SetReadOnlyValue("body", v)
+		got, err := eng.RunProgram(p)
+		if err != nil {
+			t.Errorf("%s: %v", pipeline, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: result differs from the result in memory", pipeline)
+		}
+	}
+	eng.Reset()
+	if n := files(); n != 0 {
+		t.Errorf("expected Reset to remove the temporary files, found %d", n)
+	}
+
+	// values in files are not limited by the memory limits until loaded
+	eng.Limits = Limits{MaxValueBytes: 100000, MaxTotalBytes: 100000}
+	run := func(pipeline string) error {
+		eng.Reset()
+		v, err := eng.ReadValue(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		eng.PushValue(v)
+		_, err = eng.Run(parseCommands(pipeline))
+		return err
+	}
+	if err := run("/push/hex/sha256/swap/sha256/append"); err != nil {
+		t.Errorf("expected hashing a file to succeed, got %v", err)
+	}
+	if err := run("/reverse"); !errors.Is(err, ErrValueBytes) {
+		t.Errorf("expected %v, got %v", ErrValueBytes, err)
+	}
+	eng.Limits.MaxSpillBytes = 500000
+	if err := run("/hex/sha256"); !errors.Is(err, ErrSpillBytes) {
+		t.Errorf("expected %v, got %v", ErrSpillBytes, err)
+	}
+	var oe *OpError
+	if err := run("/unhex/sha256"); !errors.As(err, &oe) || oe.Op != "unhex" {
+		t.Errorf("expected an error from unhex, got %v", err)
+	}
+	// so are the arguments of commands that move values without loading them
+	for _, pipeline := range []string{"/push/push/save", "/push/pick", "/push/roll"} {
+		if err := run(pipeline); !errors.Is(err, ErrValueBytes) {
+			t.Errorf("%s: expected %v, got %v", pipeline, ErrValueBytes, err)
+		}
+	}
+
+	// a value that cannot be read is an error
+	eng.Limits = Limits{}
+	err := eng.Register("test-pop", FuncSpec{In: "", Out: "Data", Fn: func(s *Stack, e *Engine) error {
+		if s.Pop() == nil {
+			return errors.New("expected a value")
+		}
+		s.Push([]byte("popped"))
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pipeline := range []string{"/push/pick", "/test-pop", "/x/save/x/call", "/sha256"} {
+		eng.Reset()
+		v, err := eng.ReadValue(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.(*fileValue).f.Close()
+		eng.PushValue(v)
+		if _, err = eng.Run(parseCommands(pipeline)); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("%s: expected %v, got %v", pipeline, os.ErrClosed, err)
+		}
+	}
+
+	// the debug output describes the file instead of showing it
+	eng.Reset()
+	v, _ := eng.ReadValue(bytes.NewReader(data))
+	eng.PushValue(v)
+	eng.DebugMode = true
+	eng.Tracer = new(JSONTracer)
+	out, err := eng.Run([]string{"push", "pop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("[temporary file: 300000 bytes]")) {
+		t.Errorf("expected the trace to describe the file: %.200s", out)
+	}
+	eng.Reset()
+}
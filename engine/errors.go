@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// OpError records the operation that failed during a run. When a call
+// fails, the error for the call wraps the error for the operation within it.
+type OpError struct {
+	Op         string // the command as written
+	Index      int    // the position of the command in its program, starting at 0
+	StackDepth int    // the number of values on the stack when the command started
+	Err        error
+}
+
+func (o *OpError) Error() string {
+	return fmt.Sprintf("%s (index %d, stack depth %d): %v", o.Op, o.Index, o.StackDepth, o.Err)
+}
+
+func (o *OpError) Unwrap() error {
+	return o.Err
+}
+
+// StackUnderflowError is returned when there are not enough values on the
+// stack for an operation or a program.
+type StackUnderflowError struct {
+	Op   string
+	Need int
+	Have int
+}
+
+func (s *StackUnderflowError) Error() string {
+	op := s.Op
+	if op == "" {
+		op = "operation"
+	}
+	return fmt.Sprintf("%s needs %d values on the stack but %d provided", op, s.Need, s.Have)
+}
+
+// DecodeError is returned when data is not in the expected format, such as
+// invalid hex or corrupt compressed data. Its message is the message of the
+// underlying error.
+type DecodeError struct {
+	Format string // hex, base64, integer, gzip, and so on
+	Err    error
+}
+
+func (d *DecodeError) Error() string {
+	return d.Err.Error()
+}
+
+func (d *DecodeError) Unwrap() error {
+	return d.Err
+}
+
+// InvalidArgumentError is returned when a value on the stack cannot be used
+// by an operation, such as a key of the wrong length or a negative count.
+type InvalidArgumentError struct {
+	Op  string
+	Err error
+}
+
+func (i *InvalidArgumentError) Error() string {
+	return i.Op + ": " + i.Err.Error()
+}
+
+func (i *InvalidArgumentError) Unwrap() error {
+	return i.Err
+}
+
+// VerificationFailedError is returned when a check fails, such as comparing
+// a signature or unprotecting data that was changed.
+type VerificationFailedError struct {
+	Reason string
+}
+
+func (v *VerificationFailedError) Error() string {
+	return v.Reason
+}
+
+// cause removes the OpError wrappers from an error
+func cause(err error) error {
+	for {
+		o, ok := err.(*OpError)
+		if !ok {
+			return err
+		}
+		err = o.Err
+	}
+}
+
+// decodeError wraps an error from decoding data in a DecodeError, leaving
+// errors that are already typed and cancellation errors alone
+func decodeError(format string, err error) error {
+	var (
+		le *LimitExceededError
+		su *StackUnderflowError
+		de *DecodeError
+		oe *OpError
+	)
+	if err == nil || errors.As(err, &le) || errors.As(err, &su) || errors.As(err, &de) || errors.As(err, &oe) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &DecodeError{Format: format, Err: err}
+}
+
+// invalidArg returns an InvalidArgumentError with the given message
+func invalidArg(op, format string, args ...any) error {
+	return &InvalidArgumentError{Op: op, Err: fmt.Errorf(format, args...)}
+}
+
+// UnusedValuesError is returned when a program leaves more than the one
+// value it should return on the stack.
+type UnusedValuesError struct {
+	Op   string
+	Left int
+}
+
+func (u *UnusedValuesError) Error() string {
+	return fmt.Sprintf("%s would leave %d values on the stack", u.Op, u.Left)
+}
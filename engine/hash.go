@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha3"
 	"crypto/sha512"
 	"errors"
 	"hash"
@@ -14,36 +15,46 @@ import (
 	"hash/crc64"
 	"hash/fnv"
 
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
 	"golang.org/x/crypto/ripemd160"
 )
 
-func computeHash(h hash.Hash, data []byte) ([]byte, error) {
+func (e *Engine) computeHash(h hash.Hash, data []byte) ([]byte, error) {
 	if data == nil {
 		return nil, errors.New("no data provided to hash")
 	}
-	_, err := h.Write(data)
+	err := e.write(h, data)
 	if err != nil {
 		return nil, err
 	}
+	return sum(h)
+}
+
+// sum returns the hash of the data written to h
+func sum(h hash.Hash) ([]byte, error) {
+	if s, ok := h.(shakeHash); ok {
+		return s.sum()
+	}
 	return h.Sum(nil), nil
 }
 
-func computeHmac(hf func() hash.Hash, key []byte, data []byte) ([]byte, error) {
+func (e *Engine) computeHmac(hf func() hash.Hash, key []byte, data []byte) ([]byte, error) {
 	if key == nil {
 		return nil, errors.New("no key provided for hmac")
 	}
 	/*
 		Now assuming key is already hashed by the user - more flexible
-		hashedKey, err := computeHash(hf(), key)
+		hashedKey, err := e.computeHash(hf(), key)
 		if err != nil {
 			return nil, err
 		}
 	*/
-	return computeHash(hmac.New(hf, key), data)
+	return e.computeHash(hmac.New(hf, key), data)
 }
 
 func (e *Engine) md5() error {
-	data, err := computeHash(md5.New(), e.stack.Pop())
+	data, err := e.computeHash(md5.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -51,7 +62,7 @@ func (e *Engine) md5() error {
 }
 
 func (e *Engine) sha1() error {
-	data, err := computeHash(sha1.New(), e.stack.Pop())
+	data, err := e.computeHash(sha1.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -59,7 +70,7 @@ func (e *Engine) sha1() error {
 }
 
 func (e *Engine) sha224() error {
-	data, err := computeHash(sha256.New224(), e.stack.Pop())
+	data, err := e.computeHash(sha256.New224(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -67,7 +78,7 @@ func (e *Engine) sha224() error {
 }
 
 func (e *Engine) sha256() error {
-	data, err := computeHash(sha256.New(), e.stack.Pop())
+	data, err := e.computeHash(sha256.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -75,7 +86,7 @@ func (e *Engine) sha256() error {
 }
 
 func (e *Engine) sha384() error {
-	data, err := computeHash(sha512.New384(), e.stack.Pop())
+	data, err := e.computeHash(sha512.New384(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -83,7 +94,7 @@ func (e *Engine) sha384() error {
 }
 
 func (e *Engine) sha512() error {
-	data, err := computeHash(sha512.New(), e.stack.Pop())
+	data, err := e.computeHash(sha512.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -91,7 +102,7 @@ func (e *Engine) sha512() error {
 }
 
 func (e *Engine) ripemd160() error {
-	data, err := computeHash(ripemd160.New(), e.stack.Pop())
+	data, err := e.computeHash(ripemd160.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -100,7 +111,7 @@ func (e *Engine) ripemd160() error {
 
 func (e *Engine) hmac_md5() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(md5.New, k, e.stack.Pop())
+	data, err := e.computeHmac(md5.New, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -109,7 +120,7 @@ func (e *Engine) hmac_md5() error {
 
 func (e *Engine) hmac_sha1() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(sha1.New, k, e.stack.Pop())
+	data, err := e.computeHmac(sha1.New, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -118,7 +129,7 @@ func (e *Engine) hmac_sha1() error {
 
 func (e *Engine) hmac_sha224() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(sha256.New224, k, e.stack.Pop())
+	data, err := e.computeHmac(sha256.New224, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -127,7 +138,7 @@ func (e *Engine) hmac_sha224() error {
 
 func (e *Engine) hmac_sha256() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(sha256.New, k, e.stack.Pop())
+	data, err := e.computeHmac(sha256.New, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -136,7 +147,7 @@ func (e *Engine) hmac_sha256() error {
 
 func (e *Engine) hmac_sha384() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(sha512.New384, k, e.stack.Pop())
+	data, err := e.computeHmac(sha512.New384, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -145,7 +156,7 @@ func (e *Engine) hmac_sha384() error {
 
 func (e *Engine) hmac_sha512() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(sha512.New, k, e.stack.Pop())
+	data, err := e.computeHmac(sha512.New, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -154,7 +165,7 @@ func (e *Engine) hmac_sha512() error {
 
 func (e *Engine) hmac_ripemd160() error {
 	k := e.stack.Pop()
-	data, err := computeHmac(ripemd160.New, k, e.stack.Pop())
+	data, err := e.computeHmac(ripemd160.New, k, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -162,7 +173,7 @@ func (e *Engine) hmac_ripemd160() error {
 }
 
 func (e *Engine) adler32() error {
-	data, err := computeHash(adler32.New(), e.stack.Pop())
+	data, err := e.computeHash(adler32.New(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -170,7 +181,7 @@ func (e *Engine) adler32() error {
 }
 
 func (e *Engine) crc32() error {
-	data, err := computeHash(crc32.NewIEEE(), e.stack.Pop())
+	data, err := e.computeHash(crc32.NewIEEE(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -178,7 +189,7 @@ func (e *Engine) crc32() error {
 }
 
 func (e *Engine) crc32_ieee() error {
-	data, err := computeHash(crc32.New(crc32.MakeTable(crc32.IEEE)), e.stack.Pop())
+	data, err := e.computeHash(crc32.New(crc32.MakeTable(crc32.IEEE)), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -186,7 +197,7 @@ func (e *Engine) crc32_ieee() error {
 }
 
 func (e *Engine) crc32_castagnoli() error {
-	data, err := computeHash(crc32.New(crc32.MakeTable(crc32.Castagnoli)), e.stack.Pop())
+	data, err := e.computeHash(crc32.New(crc32.MakeTable(crc32.Castagnoli)), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -194,7 +205,7 @@ func (e *Engine) crc32_castagnoli() error {
 }
 
 func (e *Engine) crc32_koopman() error {
-	data, err := computeHash(crc32.New(crc32.MakeTable(crc32.Koopman)), e.stack.Pop())
+	data, err := e.computeHash(crc32.New(crc32.MakeTable(crc32.Koopman)), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -202,7 +213,7 @@ func (e *Engine) crc32_koopman() error {
 }
 
 func (e *Engine) crc64_iso() error {
-	data, err := computeHash(crc64.New(crc64.MakeTable(crc64.ISO)), e.stack.Pop())
+	data, err := e.computeHash(crc64.New(crc64.MakeTable(crc64.ISO)), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -210,7 +221,7 @@ func (e *Engine) crc64_iso() error {
 }
 
 func (e *Engine) crc64_ecma() error {
-	data, err := computeHash(crc64.New(crc64.MakeTable(crc64.ECMA)), e.stack.Pop())
+	data, err := e.computeHash(crc64.New(crc64.MakeTable(crc64.ECMA)), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -218,7 +229,7 @@ func (e *Engine) crc64_ecma() error {
 }
 
 func (e *Engine) fnv32() error {
-	data, err := computeHash(fnv.New32(), e.stack.Pop())
+	data, err := e.computeHash(fnv.New32(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -226,7 +237,7 @@ func (e *Engine) fnv32() error {
 }
 
 func (e *Engine) fnv32a() error {
-	data, err := computeHash(fnv.New32a(), e.stack.Pop())
+	data, err := e.computeHash(fnv.New32a(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -234,7 +245,7 @@ func (e *Engine) fnv32a() error {
 }
 
 func (e *Engine) fnv64() error {
-	data, err := computeHash(fnv.New64(), e.stack.Pop())
+	data, err := e.computeHash(fnv.New64(), e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
@@ -242,18 +253,259 @@ func (e *Engine) fnv64() error {
 }
 
 func (e *Engine) fnv64a() error {
-	data, err := computeHash(fnv.New64a(), e.stack.Pop())
+	data, err := e.computeHash(fnv.New64a(), e.stack.Pop())
+	if err == nil {
+		e.stack.Push(data)
+	}
+	return err
+}
+
+// These adapt the constructors of the newer hashes to func() hash.Hash, so
+// they can be used with HMAC. The BLAKE2 hashes are unkeyed.
+
+func newSHA3_224() hash.Hash { return sha3.New224() }
+func newSHA3_256() hash.Hash { return sha3.New256() }
+func newSHA3_384() hash.Hash { return sha3.New384() }
+func newSHA3_512() hash.Hash { return sha3.New512() }
+
+func newBlake2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+func newBlake2b384() hash.Hash {
+	h, _ := blake2b.New384(nil)
+	return h
+}
+
+func newBlake2b512() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+func newBlake2s256() hash.Hash {
+	h, _ := blake2s.New256(nil)
+	return h
+}
+
+// pushHash replaces the value on the stack with its hash
+func (e *Engine) pushHash(h hash.Hash) error {
+	data, err := e.computeHash(h, e.stack.Pop())
 	if err == nil {
 		e.stack.Push(data)
 	}
 	return err
 }
 
+func (e *Engine) sha3_224() error {
+	return e.pushHash(newSHA3_224())
+}
+
+func (e *Engine) sha3_256() error {
+	return e.pushHash(newSHA3_256())
+}
+
+func (e *Engine) sha3_384() error {
+	return e.pushHash(newSHA3_384())
+}
+
+func (e *Engine) sha3_512() error {
+	return e.pushHash(newSHA3_512())
+}
+
+func (e *Engine) sha512_224() error {
+	return e.pushHash(sha512.New512_224())
+}
+
+func (e *Engine) sha512_256() error {
+	return e.pushHash(sha512.New512_256())
+}
+
+func (e *Engine) blake2b_256() error {
+	return e.pushHash(newBlake2b256())
+}
+
+func (e *Engine) blake2b_384() error {
+	return e.pushHash(newBlake2b384())
+}
+
+func (e *Engine) blake2b_512() error {
+	return e.pushHash(newBlake2b512())
+}
+
+func (e *Engine) blake2s_256() error {
+	return e.pushHash(newBlake2s256())
+}
+
+func (e *Engine) hmac_sha3_224() error {
+	return e.pushHmac(newSHA3_224)
+}
+
+func (e *Engine) hmac_sha3_256() error {
+	return e.pushHmac(newSHA3_256)
+}
+
+func (e *Engine) hmac_sha3_384() error {
+	return e.pushHmac(newSHA3_384)
+}
+
+func (e *Engine) hmac_sha3_512() error {
+	return e.pushHmac(newSHA3_512)
+}
+
+func (e *Engine) hmac_sha512_224() error {
+	return e.pushHmac(sha512.New512_224)
+}
+
+func (e *Engine) hmac_sha512_256() error {
+	return e.pushHmac(sha512.New512_256)
+}
+
+func (e *Engine) hmac_blake2b_256() error {
+	return e.pushHmac(newBlake2b256)
+}
+
+func (e *Engine) hmac_blake2b_384() error {
+	return e.pushHmac(newBlake2b384)
+}
+
+func (e *Engine) hmac_blake2b_512() error {
+	return e.pushHmac(newBlake2b512)
+}
+
+func (e *Engine) hmac_blake2s_256() error {
+	return e.pushHmac(newBlake2s256)
+}
+
+// pushHmac pops a key and replaces the value below it with its HMAC
+func (e *Engine) pushHmac(hf func() hash.Hash) error {
+	key := e.stack.Pop()
+	if key == nil {
+		return errors.New("no key provided for hmac")
+	}
+	return e.pushHash(hmac.New(hf, key))
+}
+
+// newBlake2MAC pops the key for a keyed BLAKE2 hash
+func (e *Engine) newBlake2MAC(op string, newHash func(key []byte) (hash.Hash, error)) (hash.Hash, error) {
+	key := e.stack.Pop()
+	if key == nil {
+		return nil, errors.New("no key provided for " + op)
+	}
+	h, err := newHash(key)
+	if err != nil {
+		return nil, &InvalidArgumentError{Op: op, Err: err}
+	}
+	return h, nil
+}
+
+func (e *Engine) mac_blake2b_256() error {
+	h, err := e.newBlake2MAC("mac-blake2b-256", blake2b.New256)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
+func (e *Engine) mac_blake2b_384() error {
+	h, err := e.newBlake2MAC("mac-blake2b-384", blake2b.New384)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
+func (e *Engine) mac_blake2b_512() error {
+	h, err := e.newBlake2MAC("mac-blake2b-512", blake2b.New512)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
+func (e *Engine) mac_blake2s_256() error {
+	h, err := e.newBlake2MAC("mac-blake2s-256", blake2s.New256)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
+// shakeHash adapts a SHAKE function to hash.Hash, producing size bytes
+type shakeHash struct {
+	*sha3.SHAKE
+	newShake func() *sha3.SHAKE
+	size     int
+}
+
+func (s shakeHash) Size() int {
+	return s.size
+}
+
+// Sum appends the output to b. The engine calls sum instead, which reports
+// an error copying the state.
+func (s shakeHash) Sum(b []byte) []byte {
+	out, _ := s.sum()
+	return append(b, out...)
+}
+
+func (s shakeHash) sum() ([]byte, error) {
+	// read from a copy, so that more data can be written
+	c := s.newShake()
+	state, err := s.MarshalBinary()
+	if err == nil {
+		err = c.UnmarshalBinary(state)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, s.size)
+	c.Read(out)
+	return out, nil
+}
+
+// newShake pops the output length for a SHAKE function
+func (e *Engine) newShake(op string, newShake func() *sha3.SHAKE) (hash.Hash, error) {
+	n, err := e.stack.PopInt()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, invalidArg(op, "length cannot be negative")
+	}
+	if err = e.checkSize(n); err != nil {
+		return nil, err
+	}
+	return shakeHash{SHAKE: newShake(), newShake: newShake, size: n}, nil
+}
+
+func (e *Engine) shake128() error {
+	h, err := e.newShake("shake128", sha3.NewSHAKE128)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
+func (e *Engine) shake256() error {
+	h, err := e.newShake("shake256", sha3.NewSHAKE256)
+	if err != nil {
+		return err
+	}
+	return e.pushHash(h)
+}
+
 func (e *Engine) rand() error {
 	var data []byte
 	var sz int
 	var err error
 	sz, err = e.stack.PopInt()
+	if err == nil && sz < 0 {
+		err = invalidArg("rand", "count cannot be negative")
+	}
+	if err == nil {
+		err = e.checkSize(sz)
+	}
 	if err == nil {
 		data = make([]byte, sz)
 		_, err = rand.Read(data)
@@ -298,3 +550,53 @@ func (e *Engine) ripemd160_len() error {
 	e.stack.Push([]byte("20"))
 	return nil
 }
+
+func (e *Engine) sha3_224_len() error {
+	e.stack.Push([]byte("28"))
+	return nil
+}
+
+func (e *Engine) sha3_256_len() error {
+	e.stack.Push([]byte("32"))
+	return nil
+}
+
+func (e *Engine) sha3_384_len() error {
+	e.stack.Push([]byte("48"))
+	return nil
+}
+
+func (e *Engine) sha3_512_len() error {
+	e.stack.Push([]byte("64"))
+	return nil
+}
+
+func (e *Engine) sha512_224_len() error {
+	e.stack.Push([]byte("28"))
+	return nil
+}
+
+func (e *Engine) sha512_256_len() error {
+	e.stack.Push([]byte("32"))
+	return nil
+}
+
+func (e *Engine) blake2b_256_len() error {
+	e.stack.Push([]byte("32"))
+	return nil
+}
+
+func (e *Engine) blake2b_384_len() error {
+	e.stack.Push([]byte("48"))
+	return nil
+}
+
+func (e *Engine) blake2b_512_len() error {
+	e.stack.Push([]byte("64"))
+	return nil
+}
+
+func (e *Engine) blake2s_256_len() error {
+	e.stack.Push([]byte("32"))
+	return nil
+}
@@ -13,9 +13,16 @@ func (e *Engine) Help() []byte {
 	b.Write([]byte(`<h1>hashsrv</h1>`))
 	b.Write([]byte(`hashsrv is a web service that performs hashing, encryption, encoding, and compression. Available functions include:`))
 
-	tpl.ExecuteTemplate(&b, "Funcs", e.funcMap)
-
-	tpl.ExecuteTemplate(&b, "Vars", e.values)
+	tpl.ExecuteTemplate(&b, "Funcs", e.Catalog())
+
+	// the help page is public, so secrets are left out
+	var rows []TraceValue
+	for _, r := range e.valueRows() {
+		if !e.IsSecret(r.Name) {
+			rows = append(rows, r)
+		}
+	}
+	tpl.ExecuteTemplate(&b, "Vars", rows)
 
 	b.Write([]byte(htmlFooter))
 
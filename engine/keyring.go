@@ -0,0 +1,298 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// KeyState says what a key in a Keyring may be used for.
+type KeyState int
+
+const (
+	// KeyActive keys protect new data and unprotect existing data.
+	KeyActive KeyState = iota
+	// KeyDecryptOnly keys only unprotect existing data.
+	KeyDecryptOnly
+	// KeyRetired keys are kept for reference but can no longer be used.
+	KeyRetired
+)
+
+var keyStateNames = []string{"active", "decrypt", "retired"}
+
+func (s KeyState) String() string {
+	if s >= 0 && int(s) < len(keyStateNames) {
+		return keyStateNames[s]
+	}
+	return fmt.Sprintf("KeyState(%d)", int(s))
+}
+
+// ParseKeyState returns the KeyState with the given name.
+func ParseKeyState(name string) (KeyState, error) {
+	for i, n := range keyStateNames {
+		if strings.EqualFold(name, n) {
+			return KeyState(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key state %q (expected active, decrypt, or retired)", name)
+}
+
+// Key is a named key in a Keyring.
+type Key struct {
+	ID       string
+	State    KeyState
+	Material []byte
+}
+
+// Keyring holds the keys used by the protect and unprotect commands. At most
+// one key is active; rotating keys means adding a new active key and marking
+// the old one decrypt-only, so existing data can still be unprotected.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active *Key
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// Add adds a key to the keyring. The key material must be 16, 24, or 32
+// bytes long to select AES-128, AES-192, or AES-256.
+func (k *Keyring) Add(id string, state KeyState, material []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("key ID %q must be between 1 and 255 bytes", id)
+	}
+	if state < KeyActive || state > KeyRetired {
+		return fmt.Errorf("key %q has an invalid state", id)
+	}
+	switch len(material) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("key %q must be 16, 24, or 32 bytes, not %d", id, len(material))
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	if state == KeyActive && k.active != nil {
+		return fmt.Errorf("key %q cannot be active because key %q is already active", id, k.active.ID)
+	}
+	key := &Key{ID: id, State: state, Material: bytes.Clone(material)}
+	k.keys[id] = key
+	if state == KeyActive {
+		k.active = key
+	}
+	return nil
+}
+
+// Key returns the key with the given ID.
+func (k *Keyring) Key(id string) (*Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Active returns the key used to protect new data.
+func (k *Keyring) Active() (*Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active, k.active != nil
+}
+
+// LoadDir adds the keys in a folder. Each file is named <id>.<state>, where
+// state is active, decrypt, or retired, and contains the hex key material.
+// Files starting with a dot are ignored.
+func (k *Keyring) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		dot := strings.LastIndexByte(name, '.')
+		if dot < 0 {
+			return fmt.Errorf("key file %q must be named <id>.<state>", name)
+		}
+		state, err := ParseKeyState(name[dot+1:])
+		if err != nil {
+			return fmt.Errorf("key file %q: %w", name, err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		material, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("key file %q: %w", name, err)
+		}
+		if err = k.Add(name[:dot], state, material); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSpec adds the keys in a comma-separated list of id:state:hex entries,
+// such as "k2:active:<hex>,k1:decrypt:<hex>".
+func (k *Keyring) LoadSpec(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("key entry %q must be formatted as id:state:hex", parts[0])
+		}
+		state, err := ParseKeyState(parts[1])
+		if err != nil {
+			return fmt.Errorf("key %q: %w", parts[0], err)
+		}
+		material, err := hex.DecodeString(parts[2])
+		if err != nil {
+			return fmt.Errorf("key %q: %w", parts[0], err)
+		}
+		if err = k.Add(parts[0], state, material); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Protected data is an envelope with this layout:
+
+	version   1 byte    envelopeVersion
+	algorithm 1 byte    algAESGCM
+	key ID    1 byte length, then the ID
+	nonce     1 byte length, then the nonce
+	data      the AEAD ciphertext, including the tag
+
+	Everything before the data is the header, which is authenticated as
+	additional data so it cannot be changed without unprotect failing.
+*/
+
+const (
+	envelopeVersion = 1
+	algAESGCM       = 1
+)
+
+// ErrNoKeyring is returned by protect and unprotect when the engine has no Keyring.
+var ErrNoKeyring = errors.New("no keyring configured")
+
+// protect encrypts data with the active key
+func (e *Engine) protect() error {
+	data := e.stack.Pop()
+	if data == nil {
+		return errors.New("protect: expected data on the stack")
+	}
+	if e.Keyring == nil {
+		return ErrNoKeyring
+	}
+	key, ok := e.Keyring.Active()
+	if !ok {
+		return errors.New("protect: no active key")
+	}
+	aead, err := newGCM(key.Material)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, 4+len(key.ID)+aead.NonceSize())
+	header = append(header, envelopeVersion, algAESGCM, byte(len(key.ID)))
+	header = append(header, key.ID...)
+	header = append(header, byte(aead.NonceSize()))
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	header = append(header, nonce...)
+
+	out := make([]byte, len(header), len(header)+len(data)+aead.Overhead())
+	copy(out, header)
+	e.stack.Push(aead.Seal(out, nonce, data, header))
+	return nil
+}
+
+// unprotect decrypts data using the key named in the envelope
+func (e *Engine) unprotect() error {
+	env := e.stack.Pop()
+	if env == nil {
+		return errors.New("unprotect: expected data on the stack")
+	}
+	if e.Keyring == nil {
+		return ErrNoKeyring
+	}
+	id, nonce, header, err := parseEnvelope(env)
+	if err != nil {
+		return &DecodeError{Format: "envelope", Err: err}
+	}
+	key, ok := e.Keyring.Key(id)
+	if !ok {
+		return &VerificationFailedError{Reason: fmt.Sprintf("unprotect: unknown key %q", id)}
+	}
+	if key.State == KeyRetired {
+		return &VerificationFailedError{Reason: fmt.Sprintf("unprotect: key %q is retired", id)}
+	}
+	aead, err := newGCM(key.Material)
+	if err != nil {
+		return err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return &DecodeError{Format: "envelope", Err: errors.New("invalid nonce size")}
+	}
+	data, err := aead.Open(nil, nonce, env[len(header):], header)
+	if err != nil {
+		return &VerificationFailedError{Reason: "unprotect: message authentication failed"}
+	}
+	e.addSecret(data)
+	e.stack.Push(data)
+	return nil
+}
+
+// parseEnvelope splits the header of protected data
+func parseEnvelope(env []byte) (id string, nonce, header []byte, err error) {
+	if len(env) < 3 {
+		return "", nil, nil, errors.New("data is too short")
+	}
+	if env[0] != envelopeVersion {
+		return "", nil, nil, fmt.Errorf("unsupported version %d", env[0])
+	}
+	if env[1] != algAESGCM {
+		return "", nil, nil, fmt.Errorf("unsupported algorithm %d", env[1])
+	}
+	p := 3 + int(env[2])
+	if len(env) < p+1 {
+		return "", nil, nil, errors.New("data is too short")
+	}
+	id = string(env[3:p])
+	n := p + 1 + int(env[p])
+	if len(env) < n {
+		return "", nil, nil, errors.New("data is too short")
+	}
+	return id, env[p+1 : n], env[:n], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
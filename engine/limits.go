@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Limits bounds the resources used by a single run of the engine. A zero
+// value for any field means that there is no limit.
+type Limits struct {
+	MaxCallDepth  int           // how deeply programs may call other programs
+	MaxStackItems int           // the most values allowed on the stack
+	MaxValueBytes int           // the largest size of any single value held in memory
+	MaxTotalBytes int           // the largest combined size of the values on the stack and in variables held in memory
+	MaxSpillBytes int           // the largest combined size of the temporary files holding values
+	MaxOps        int           // the most operations executed, including within calls
+	Timeout       time.Duration // how long a run may take
+}
+
+// These errors identify which limit was exceeded. They are wrapped in a
+// LimitExceededError, so use errors.Is to check for them.
+var (
+	ErrCallDepth  = errors.New("call depth limit exceeded")
+	ErrStackItems = errors.New("stack item limit exceeded")
+	ErrValueBytes = errors.New("value size limit exceeded")
+	ErrTotalBytes = errors.New("total size limit exceeded")
+	ErrSpillBytes = errors.New("temporary file size limit exceeded")
+	ErrOps        = errors.New("operation limit exceeded")
+	ErrTimeout    = errors.New("time limit exceeded")
+)
+
+// LimitExceededError is returned when a run trips one of the engine limits.
+type LimitExceededError struct {
+	Err   error // one of the Err variables above
+	Limit int64 // the configured limit
+}
+
+func (l *LimitExceededError) Error() string {
+	if l.Err == ErrTimeout {
+		return fmt.Sprintf("%s (%s)", l.Err, time.Duration(l.Limit))
+	}
+	return fmt.Sprintf("%s (%d)", l.Err, l.Limit)
+}
+
+func (l *LimitExceededError) Unwrap() error {
+	return l.Err
+}
+
+// startLimits resets the counters used to enforce the limits for a new run
+func (e *Engine) startLimits() {
+	e.depth = 0
+	e.ops = 0
+	e.deadline = time.Time{}
+	if e.Limits.Timeout > 0 {
+		e.deadline = time.Now().Add(e.Limits.Timeout)
+	}
+}
+
+// checkOp is called before each operation
+func (e *Engine) checkOp() error {
+	e.ops++
+	if e.Limits.MaxOps > 0 && e.ops > e.Limits.MaxOps {
+		return &LimitExceededError{Err: ErrOps, Limit: int64(e.Limits.MaxOps)}
+	}
+	return e.checkContext()
+}
+
+// checkContext reports whether the run was cancelled or ran out of time. It
+// is checked between operations and within long running operations.
+func (e *Engine) checkContext() error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	if !e.deadline.IsZero() && time.Now().After(e.deadline) {
+		return &LimitExceededError{Err: ErrTimeout, Limit: int64(e.Limits.Timeout)}
+	}
+	return nil
+}
+
+// checkStack is called after each operation. Values in temporary files
+// count toward MaxSpillBytes instead.
+func (e *Engine) checkStack() error {
+	if e.Limits.MaxStackItems > 0 && e.stack.Len() > e.Limits.MaxStackItems {
+		return &LimitExceededError{Err: ErrStackItems, Limit: int64(e.Limits.MaxStackItems)}
+	}
+	if err := e.checkSize(e.stack.takeLargest()); err != nil {
+		return err
+	}
+	if e.Limits.MaxTotalBytes > 0 && e.stack.size+e.varSize > e.Limits.MaxTotalBytes {
+		return &LimitExceededError{Err: ErrTotalBytes, Limit: int64(e.Limits.MaxTotalBytes)}
+	}
+	return nil
+}
+
+// checkSize verifies that a value of the given size may be created
+func (e *Engine) checkSize(n int) error {
+	if e.Limits.MaxValueBytes > 0 && n > e.Limits.MaxValueBytes {
+		return &LimitExceededError{Err: ErrValueBytes, Limit: int64(e.Limits.MaxValueBytes)}
+	}
+	return nil
+}
+
+// chunkSize is how much data is processed between cancellation checks
+const chunkSize = 64 * 1024
+
+// write writes data in chunks, stopping if the run is cancelled
+func (e *Engine) write(w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		if err := e.checkContext(); err != nil {
+			return err
+		}
+		n := min(len(data), chunkSize)
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// checkedReader stops reading if the run is cancelled
+type checkedReader struct {
+	e *Engine
+	r io.Reader
+}
+
+func (c checkedReader) Read(p []byte) (int, error) {
+	if err := c.e.checkContext(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// readAll reads the output of a decompressor, stopping if the run is
+// cancelled or once the value size limit is exceeded
+func (e *Engine) readAll(r io.Reader) ([]byte, error) {
+	r = checkedReader{e: e, r: r}
+	if e.Limits.MaxValueBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(e.Limits.MaxValueBytes)+1))
+	if err == nil {
+		err = e.checkSize(len(data))
+	}
+	return data, err
+}
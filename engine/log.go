@@ -1,9 +1,11 @@
 package engine
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
+	"sort"
 )
 
 const (
@@ -65,55 +67,159 @@ tbody tr:hover td
 	templates = `
 {{define "Text"}}<h2>{{.}}</h2>{{end}}
 {{define "Vars"}}Variables:<table><thead><tr><th>Name</th><th>Length</th><th>Text</th><th>Bytes</th></tr></thead><tbody>
-{{range $n, $v := .}}<tr><td>{{$n}}</td><td>{{len $v}}</td><td class="fixed">{{$v | printf "%+q"}}</td><td>{{ $v | printf "% x"}}</td></tr>{{end}}
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Length}}</td><td class="fixed">{{.Text}}</td><td>{{.Hex}}</td></tr>{{end}}
 </tbody></table>
 {{end}}
 {{define "Stack"}}<table><thead><tr><th>Position</th><th>Length</th><th>Text</th><th>Bytes</th></tr></thead><tbody>
-{{range $i, $v := .}}<tr><td>{{$i}}</td><td>{{len $v}}</td><td class="fixed">{{$v | printf "%+q"}}</td><td>{{ $v | printf "% x"}}</td></tr>{{end}}
+{{range $i, $v := .}}<tr><td>{{$i}}</td><td>{{$v.Length}}</td><td class="fixed">{{$v.Text}}</td><td>{{$v.Hex}}</td></tr>{{end}}
 </tbody></table>
 {{end}}
-{{define "Funcs"}}<table><thead><tr><th>Stack In</th><th>Function</th><th>Stack Out</th><th>Description</th></tr></thead><tbody>
-{{range $k, $v := .}}<tr><td>{{$v.In}}</td><td><b>{{$k}}</b></td><td>{{$v.Out}}</td><td>{{$v.Desc}}</td></tr>{{end}}
+{{define "Timing"}}<div style="margin-left: {{indent .Depth}}px">{{.Op}} took {{.Duration}}, {{.BytesIn}} bytes in, {{.BytesOut}} bytes out</div>{{end}}
+{{define "Summary"}}<h2>Took {{.Duration}}</h2><table><thead><tr><th>Operation</th><th>Count</th><th>Time</th><th>Bytes In</th><th>Bytes Out</th></tr></thead><tbody>
+{{range .Summary}}<tr><td>{{.Op}}</td><td>{{.Count}}</td><td>{{.Duration}}</td><td>{{.BytesIn}}</td><td>{{.BytesOut}}</td></tr>{{end}}
 </tbody></table>
 {{end}}
+{{define "Funcs"}}<table><thead><tr><th>Category</th><th>Stack In</th><th>Function</th><th>Stack Out</th><th>Description</th></tr></thead><tbody>
+{{range .}}<tr><td>{{.Category}}</td><td>{{.In}}</td><td><b><a href="/help/{{.Name}}">{{.Name}}</a></b></td><td>{{.Out}}</td><td>{{.Desc}}</td></tr>{{end}}
+</tbody></table>
+{{end}}
+{{define "Func"}}<h1>{{.Name}}</h1>
+<p>{{.Desc}}</p>
+<table><tbody>
+<tr><td>Category</td><td>{{.Category}}</td></tr>
+<tr><td>Stack In</td><td>{{.In}}</td></tr>
+<tr><td>Stack Out</td><td>{{.Out}}</td></tr>
+</tbody></table>
+{{if .Examples}}<h2>Examples</h2><table><thead><tr><th>Pipeline</th><th>Input</th><th>Output</th></tr></thead><tbody>
+{{range .Examples}}<tr><td>{{if .Input}}POST{{else}}GET{{end}} {{.Pipeline}}</td><td class="fixed">{{.Input | printf "%+q"}}</td><td class="fixed">{{.Output | printf "%+q"}}</td></tr>{{end}}
+</tbody></table>{{end}}
+<p><a href="/">All functions</a></p>
+{{end}}
 `
 )
 
 var (
-	tpl = template.Must(template.New("templates").Parse(templates))
+	tpl = template.Must(template.New("templates").Funcs(template.FuncMap{
+		"indent": func(depth int) int { return 20 * (depth - 1) },
+	}).Parse(templates))
 )
 
+// logger returns the engine's logger, or the default logger if it has none
+func (e *Engine) logger() *slog.Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return slog.Default()
+}
+
+// debugEnabled reports whether the logger writes debug messages
+func (e *Engine) debugEnabled() bool {
+	return e.logger().Enabled(e.ctx, slog.LevelDebug)
+}
+
 // Log writes information to the debug log
 func (e *Engine) Log(parms ...interface{}) {
-	log.Print(parms...)
-	if e.DebugMode {
-		// ignoring errors
-		tpl.ExecuteTemplate(e.logBuf, "Text", fmt.Sprint(parms...))
+	debug := e.debugEnabled()
+	if !debug && e.trace == nil {
+		return
+	}
+	msg := fmt.Sprint(parms...)
+	if debug {
+		e.logger().DebugContext(e.ctx, msg, "depth", e.depth)
+	}
+	if e.trace != nil {
+		e.trace.Message(msg)
 	}
 }
 
 // Logf writes formatted information to the debug log
 func (e *Engine) Logf(format string, parms ...interface{}) {
-	log.Printf(format, parms...)
-	if e.DebugMode {
-		// ignoring errors
-		tpl.ExecuteTemplate(e.logBuf, "Text", fmt.Sprintf(format, parms...))
+	debug := e.debugEnabled()
+	if !debug && e.trace == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, parms...)
+	if debug {
+		e.logger().DebugContext(e.ctx, msg, "depth", e.depth)
+	}
+	if e.trace != nil {
+		e.trace.Message(msg)
+	}
+}
+
+// traceValue prepares a value for display
+func traceValue(name string, v []byte, secret bool) TraceValue {
+	if secret {
+		r := redact(v)
+		return TraceValue{Name: name, Length: len(v), Text: r, Hex: r, Secret: true}
+	}
+	return TraceValue{Name: name, Length: len(v), Text: fmt.Sprintf("%+q", v), Hex: fmt.Sprintf("% x", v)}
+}
+
+// redact describes a secret by its length and a short fingerprint, so that
+// values can be compared without being shown
+func redact(v []byte) string {
+	sum := sha256.Sum256(v)
+	return fmt.Sprintf("[secret: %d bytes, sha256 %x]", len(v), sum[:4])
+}
+
+// valueRows prepares the variables for display, sorted by name
+func (e *Engine) valueRows() []TraceValue {
+	vars := e.variables()
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
 	}
+	sort.Strings(names)
+	rows := make([]TraceValue, len(names))
+	for i, k := range names {
+		rows[i] = e.variableValue(k, vars[k])
+	}
+	return rows
 }
 
+// stackValues prepares the stack for display, ending with the top
+func (e *Engine) stackValues() []TraceValue {
+	a := e.stack.Values()
+	rows := make([]TraceValue, len(a))
+	for i, v := range a {
+		rows[i] = e.stackValue(v)
+	}
+	return rows
+}
+
+// variableValue prepares a variable for display, redacting it when it is
+// secret or holds a secret value
+func (e *Engine) variableValue(name string, v Value) TraceValue {
+	b, _ := v.(bytesValue)
+	return valueTrace(name, v, e.IsSecret(name) || e.isSecretValue(b))
+}
+
+// stackValue prepares a value on the stack for display
+func (e *Engine) stackValue(v Value) TraceValue {
+	b, _ := v.(bytesValue)
+	return valueTrace("", v, e.isSecretValue(b))
+}
+
+// LogValues writes the variables to the debug log, with secrets redacted
 func (e *Engine) LogValues() {
-	for k, v := range e.values {
-		log.Printf("%s = %+q [% x]", k, string(v), v)
+	rows := e.valueRows()
+	if e.debugEnabled() {
+		for _, r := range rows {
+			e.logger().DebugContext(e.ctx, "variable", "name", r.Name, "text", r.Text, "hex", r.Hex)
+		}
 	}
-	if e.DebugMode {
-		tpl.ExecuteTemplate(e.logBuf, "Vars", e.values)
+	if t, ok := e.trace.(tableTracer); ok {
+		t.table("Vars", rows)
 	}
 }
 
+// LogStack writes the stack to the debug log
 func (e *Engine) LogStack() {
-	log.Print("Stack length: ", e.stack.Len())
-	if e.DebugMode {
-		a := e.stack.ToArray()
-		tpl.ExecuteTemplate(e.logBuf, "Stack", a)
+	if e.debugEnabled() {
+		e.logger().DebugContext(e.ctx, "stack", "length", e.stack.Len())
+	}
+	if t, ok := e.trace.(tableTracer); ok {
+		t.table("Stack", e.stackValues())
 	}
 }
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var errOverflow = errors.New("integer overflow")
+
+// popOperand pops a decimal integer for an arithmetic operation
+func (e *Engine) popOperand(op string) (int64, error) {
+	b := e.stack.Pop()
+	if b == nil {
+		return 0, &StackUnderflowError{Op: op, Need: 1}
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, &DecodeError{Format: "integer", Err: fmt.Errorf("operand %+q is not a 64-bit integer", b)})
+	}
+	return n, nil
+}
+
+// arith pops two integers and pushes the result of f. The value that was on
+// top of the stack is the second operand, so /7/3/sub is 4.
+func (e *Engine) arith(op string, f func(a, b int64) (int64, error)) error {
+	b, err := e.popOperand(op)
+	if err != nil {
+		return err
+	}
+	a, err := e.popOperand(op)
+	if err != nil {
+		return err
+	}
+	r, err := f(a, b)
+	if err != nil {
+		return &InvalidArgumentError{Op: op, Err: err}
+	}
+	e.stack.Push([]byte(strconv.FormatInt(r, 10)))
+	return nil
+}
+
+// compare pops two integers and pushes 1 if f is true and 0 otherwise
+func (e *Engine) compare(op string, f func(a, b int64) bool) error {
+	return e.arith(op, func(a, b int64) (int64, error) {
+		if f(a, b) {
+			return 1, nil
+		}
+		return 0, nil
+	})
+}
+
+func add64(a, b int64) (int64, error) {
+	r := a + b
+	if (b > 0 && r < a) || (b < 0 && r > a) {
+		return 0, errOverflow
+	}
+	return r, nil
+}
+
+func sub64(a, b int64) (int64, error) {
+	r := a - b
+	if (b > 0 && r > a) || (b < 0 && r < a) {
+		return 0, errOverflow
+	}
+	return r, nil
+}
+
+func mul64(a, b int64) (int64, error) {
+	if a == 0 || b == 0 {
+		return 0, nil
+	}
+	r := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) || r/b != a {
+		return 0, errOverflow
+	}
+	return r, nil
+}
+
+func div64(a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	if a == math.MinInt64 && b == -1 {
+		return 0, errOverflow
+	}
+	return a / b, nil
+}
+
+func mod64(a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a % b, nil
+}
+
+func (e *Engine) add() error {
+	return e.arith("add", add64)
+}
+
+func (e *Engine) sub() error {
+	return e.arith("sub", sub64)
+}
+
+func (e *Engine) mul() error {
+	return e.arith("mul", mul64)
+}
+
+func (e *Engine) div() error {
+	return e.arith("div", div64)
+}
+
+func (e *Engine) mod() error {
+	return e.arith("mod", mod64)
+}
+
+func (e *Engine) min() error {
+	return e.arith("min", func(a, b int64) (int64, error) { return min(a, b), nil })
+}
+
+func (e *Engine) max() error {
+	return e.arith("max", func(a, b int64) (int64, error) { return max(a, b), nil })
+}
+
+func (e *Engine) lt() error {
+	return e.compare("lt", func(a, b int64) bool { return a < b })
+}
+
+func (e *Engine) gt() error {
+	return e.compare("gt", func(a, b int64) bool { return a > b })
+}
+
+func (e *Engine) le() error {
+	return e.compare("le", func(a, b int64) bool { return a <= b })
+}
+
+func (e *Engine) ge() error {
+	return e.compare("ge", func(a, b int64) bool { return a >= b })
+}
+
+func (e *Engine) inc() error {
+	e.stack.Push([]byte("1"))
+	return e.arith("inc", add64)
+}
+
+func (e *Engine) dec() error {
+	e.stack.Push([]byte("1"))
+	return e.arith("dec", sub64)
+}
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxMacroDepth limits how deeply built-in macros are expanded by Compile
+const maxMacroDepth = 8
+
+// op is a single compiled command
+type op struct {
+	name  string    // the command as written
+	fd    *funcInfo // the function to call, if any
+	lit   []byte    // the value to push for literals
+	macro *Program  // the expanded built-in macro for name/call pairs
+	src   []byte    // the macro source, used to detect a replaced macro
+	in    int       // values the function takes from the stack, or -1 when it varies
+	word  bool      // an unknown word, which may be defined by the engine
+}
+
+// A Program is a list of commands that has been compiled once and can be
+// run many times, by any number of engines, using RunProgram.
+type Program struct {
+	text   string
+	ops    []op
+	needs  int      // values required on the stack before running
+	effect int      // net change to the stack size
+	known  bool     // false when the stack effect can't be determined
+	words  []string // unknown words, which engines may define with Register
+	macros []macro  // expanded macros, including those within other macros
+}
+
+// macro records the source of an expanded macro, since an engine that
+// replaces it runs its own version instead
+type macro struct {
+	name string
+	src  []byte
+}
+
+// Compile resolves the given commands into a Program. Literals are decoded,
+// calls of built-in macros are expanded, and the stack effect is computed
+// from the documented inputs and outputs of each function so that RunProgram
+// can reject a program that cannot succeed before doing any work.
+func Compile(commands []string) (*Program, error) {
+	return compile(commands, 0)
+}
+
+func compile(commands []string, depth int) (*Program, error) {
+	p := &Program{
+		text:  "/" + strings.Join(commands, "/"),
+		ops:   make([]op, 0, len(commands)),
+		known: true,
+	}
+	for i := 0; i < len(commands); i++ {
+		s := commands[i]
+		lit, isLit, err := parseLiteral(s)
+		if err != nil {
+			return nil, err
+		}
+		if isLit {
+			p.push(op{name: s, lit: lit[:len(lit):len(lit)]})
+			continue
+		}
+		if fd, ok := lookupFunc(strings.TrimSpace(s)); ok {
+			p.push(op{name: s, fd: &fd, in: countValues(fd.In)})
+			continue
+		}
+		if i+1 < len(commands) && strings.TrimSpace(commands[i+1]) == "call" && depth < maxMacroDepth {
+			if src, ok := defaultValues[s]; ok {
+				m, err := compile(parseCommands(string(src)), depth+1)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", s, err)
+				}
+				p.push(op{name: s, macro: m, src: src})
+				p.macros = append(p.macros, macro{name: s, src: src})
+				p.macros = append(p.macros, m.macros...)
+				i++
+				continue
+			}
+		}
+		b := []byte(s)
+		p.push(op{name: s, lit: b[:len(b):len(b)], word: true})
+		p.words = append(p.words, strings.TrimSpace(s))
+	}
+	return p, nil
+}
+
+// push adds an op to the program and tracks its effect on the stack
+func (p *Program) push(o op) {
+	p.ops = append(p.ops, o)
+	if !p.known {
+		return
+	}
+	in, out, ok := o.effect()
+	if !ok {
+		p.known = false
+		return
+	}
+	if in-p.effect > p.needs {
+		p.needs = in - p.effect
+	}
+	p.effect += out - in
+}
+
+// effect returns the number of values the op takes from the stack and the
+// number it leaves there, or false when the numbers vary
+func (o *op) effect() (in, out int, ok bool) {
+	switch {
+	case o.fd != nil:
+		in, out = o.in, countValues(o.fd.Out)
+		return in, out, in >= 0 && out >= 0
+	case o.macro != nil:
+		return o.macro.needs, o.macro.needs + o.macro.effect, o.macro.known
+	}
+	return 0, 1, true
+}
+
+// countValues counts the values in an In or Out description, returning -1
+// when the number varies
+func countValues(desc string) int {
+	desc = strings.TrimSpace(desc)
+	if desc == "" {
+		return 0
+	}
+	if strings.HasPrefix(desc, "(") {
+		return -1
+	}
+	return len(strings.Split(desc, ","))
+}
+
+// check verifies that running the program on the engine's stack leaves a
+// single value to return
+func (p *Program) check(e *Engine) error {
+	if !p.known {
+		return nil
+	}
+	for _, w := range p.words {
+		if _, ok := e.funcs[w]; ok {
+			// the stack effect of the engine's own functions wasn't known at compile time
+			return nil
+		}
+	}
+	for _, m := range p.macros {
+		if !e.valueIs(m.name, m.src) {
+			// the engine replaced the macro, so its stack effect isn't known
+			return nil
+		}
+	}
+	size := e.stack.Len()
+	if size < p.needs {
+		return &StackUnderflowError{Op: p.String(), Need: p.needs, Have: size}
+	}
+	if size+p.effect != 1 {
+		return &UnusedValuesError{Op: p.String(), Left: size + p.effect}
+	}
+	return nil
+}
+
+// Redacted returns the program in URL form with each literal replaced by *,
+// so it can be logged without the keys and other data it may hold
+func (p *Program) Redacted() string {
+	if len(p.ops) == 0 {
+		return "/"
+	}
+	var b strings.Builder
+	for _, o := range p.ops {
+		b.WriteByte('/')
+		switch {
+		case o.fd != nil:
+			b.WriteString(o.name)
+		case o.macro != nil:
+			b.WriteString(o.name + "/call")
+		default:
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
+
+// Loads reports whether the program may load the named variable. It also
+// returns true when the program loads a variable whose name is only known
+// when it runs.
+func (p *Program) Loads(name string) bool {
+	for i := range p.ops {
+		o := &p.ops[i]
+		if o.macro != nil && o.macro.Loads(name) {
+			return true
+		}
+		if o.fd == nil || strings.TrimSpace(o.name) != "load" {
+			continue
+		}
+		if i == 0 || p.ops[i-1].lit == nil {
+			return true
+		}
+		if strings.EqualFold(string(p.ops[i-1].lit), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the program in URL form
+func (p *Program) String() string {
+	return p.text
+}
+
+// parseLiteral decodes commands written using the literal syntax, which
+// is a quote prefix ('md5 pushes the text "md5") or a typed prefix that is
+// decoded on push (hex:, b64:, or b64url:). The second return value is false
+// when the command is not written as a literal.
+func parseLiteral(s string) ([]byte, bool, error) {
+	var err error
+	var b []byte
+	var format string
+	switch {
+	case strings.HasPrefix(s, "'"):
+		b = []byte(s[1:])
+	case strings.HasPrefix(s, "hex:"):
+		format = "hex"
+		b, err = hex.DecodeString(s[4:])
+	case strings.HasPrefix(s, "b64:"):
+		format = "base64"
+		b, err = base64.StdEncoding.DecodeString(s[4:])
+	case strings.HasPrefix(s, "b64url:"):
+		format = "base64-url"
+		b, err = base64.URLEncoding.DecodeString(s[7:])
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("literal %+q: %w", s, &DecodeError{Format: format, Err: err})
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, true, nil
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FuncSpec describes a function that can be added to the engine with Register.
+// In and Out document the values the function takes from and leaves on the
+// stack, separated by commas, just like the built-in functions. They are also
+// used to check the stack effect of programs, so use "(varies)" when the
+// number of values is not fixed. Category and Examples are shown in the help.
+type FuncSpec struct {
+	In       string
+	Out      string
+	Desc     string
+	Category string
+	Examples []Example
+	Fn       func(s *Stack, e *Engine) error
+}
+
+// funcMu protects funcMap from concurrent registration
+var funcMu sync.RWMutex
+
+// lookupFunc finds a built-in or globally registered function
+func lookupFunc(name string) (funcInfo, bool) {
+	funcMu.RLock()
+	defer funcMu.RUnlock()
+	fd, ok := funcMap[name]
+	return fd, ok
+}
+
+// Register adds a function that is available to every engine. It should be
+// called during initialization, because programs that were compiled earlier
+// treat the name as a literal. It fails if the name is already in use.
+func Register(name string, spec FuncSpec) error {
+	fd, err := newFuncInfo(name, spec)
+	if err != nil {
+		return err
+	}
+	funcMu.Lock()
+	defer funcMu.Unlock()
+	if _, ok := funcMap[name]; ok {
+		return fmt.Errorf("register: %s is already defined", name)
+	}
+	funcMap[name] = fd
+	return nil
+}
+
+// Register adds a function that is only available to this engine. The
+// function is kept when the engine is Reset. It fails if the name is already
+// in use.
+func (e *Engine) Register(name string, spec FuncSpec) error {
+	fd, err := newFuncInfo(name, spec)
+	if err != nil {
+		return err
+	}
+	if _, ok := lookupFunc(name); ok {
+		return fmt.Errorf("register: %s is already defined", name)
+	}
+	if _, ok := e.funcs[name]; ok {
+		return fmt.Errorf("register: %s is already defined", name)
+	}
+	if e.funcs == nil {
+		e.funcs = make(map[string]funcInfo)
+	}
+	e.funcs[name] = fd
+	return nil
+}
+
+// newFuncInfo validates a registration
+func newFuncInfo(name string, spec FuncSpec) (funcInfo, error) {
+	if spec.Fn == nil {
+		return funcInfo{}, errors.New("register: no function given for " + name)
+	}
+	if name == "" || name != strings.TrimSpace(name) || strings.Contains(name, "/") {
+		return funcInfo{}, fmt.Errorf("register: invalid name %+q", name)
+	}
+	if _, isLit, _ := parseLiteral(name); isLit {
+		return funcInfo{}, fmt.Errorf("register: %s uses the literal syntax", name)
+	}
+	fn := spec.Fn
+	return funcInfo{
+		f:    func(e *Engine) error { return fn(e.stack, e) },
+		In:   spec.In,
+		Out:  spec.Out,
+		Desc: spec.Desc,
+
+		Category: spec.Category,
+		Examples: spec.Examples,
+	}, nil
+}
+
+// functions returns all of the functions available to this engine
+func (e *Engine) functions() map[string]funcInfo {
+	funcMu.RLock()
+	defer funcMu.RUnlock()
+	m := make(map[string]funcInfo, len(funcMap)+len(e.funcs))
+	for k, v := range funcMap {
+		m[k] = v
+	}
+	for k, v := range e.funcs {
+		m[k] = v
+	}
+	return m
+}
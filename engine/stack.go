@@ -2,82 +2,213 @@ package engine
 
 import (
 	"container/list"
-	"errors"
 	"strconv"
 )
 
-type Stack list.List
+type Stack struct {
+	values  list.List
+	size    int   // the combined size of the values held in memory
+	largest int   // the largest value held in memory pushed since takeLargest
+	err     error // the first error reading a value in a temporary file
+}
 
 func NewStack() *Stack {
-	return (*Stack)(list.New())
+	return new(Stack)
 }
 
 func (stack *Stack) Len() int {
-	list := (*list.List)(stack)
-	return list.Len()
+	return stack.values.Len()
 }
 
 func (stack *Stack) Push(data []byte) {
-	list := (*list.List)(stack)
-	list.PushFront(data)
+	stack.PushValue(bytesValue(data))
+}
+
+// PushValue pushes a value that may be kept in a temporary file.
+func (stack *Stack) PushValue(v Value) {
+	stack.values.PushFront(v)
+	stack.added(v)
 }
 
 func (stack *Stack) Top() []byte {
-	list := (*list.List)(stack)
-	el := list.Front()
-	if el == nil {
-		return nil
-	}
-	val, ok := el.Value.([]byte)
-	if !ok {
-		panic("Why is it not a byte array?")
-	}
-	return val
+	return stack.bytes(stack.PeekValue(0))
 }
 
 func (stack *Stack) Pop() []byte {
-	list := (*list.List)(stack)
-	el := list.Front()
+	return stack.bytes(stack.PopValue())
+}
+
+// PopValue removes the value on the top of the stack without loading it
+// into memory, or returns nil if the stack is empty.
+func (stack *Stack) PopValue() Value {
+	el := stack.values.Front()
 	if el == nil {
 		return nil
 	}
-	val, ok := el.Value.([]byte)
-	if !ok {
-		panic("Why is it not a byte array?")
-	}
-	list.Remove(el)
-	return val
+	stack.values.Remove(el)
+	stack.size -= memSize(value(el))
+	return value(el)
 }
 
 func (stack *Stack) PopInt() (int, error) {
-	val := stack.Pop()
-	if val == nil {
-		return -1, errors.New("Stack empty - integer required")
+	val, err := stack.popBytes()
+	if err != nil {
+		return -1, err
 	}
 	n, err := strconv.ParseInt(string(val), 10, 32)
 	if err != nil {
-		return -1, err
+		return -1, &DecodeError{Format: "integer", Err: err}
 	}
 	return int(n), nil
 }
 
 func (stack *Stack) PopString() (string, error) {
-	val := stack.Pop()
-	if val == nil {
-		return "", errors.New("Stack empty - string required")
+	val, err := stack.popBytes()
+	if err != nil {
+		return "", err
 	}
 	return string(val), nil
 }
 
+// popBytes removes the value on the top of the stack and returns its
+// contents, reporting an empty stack or an error reading a temporary file
+func (stack *Stack) popBytes() ([]byte, error) {
+	v := stack.PopValue()
+	if v == nil {
+		return nil, &StackUnderflowError{Need: 1}
+	}
+	return valueBytes(v)
+}
+
 func (stack *Stack) ToArray() [][]byte {
 	x := make([][]byte, 0, 4)
-	list := (*list.List)(stack)
-	for el := list.Back(); el != nil; el = el.Prev() {
-		val, ok := el.Value.([]byte)
-		if !ok {
-			panic("Why is it not a byte array?")
-		}
-		x = append(x, val)
+	for _, v := range stack.Values() {
+		x = append(x, stack.bytes(v))
 	}
 	return x
 }
+
+// Values returns the values on the stack, starting with the bottom, without
+// loading them into memory.
+func (stack *Stack) Values() []Value {
+	x := make([]Value, 0, 4)
+	for el := stack.values.Back(); el != nil; el = el.Prev() {
+		x = append(x, value(el))
+	}
+	return x
+}
+
+func (stack *Stack) Clone() *Stack {
+	c := NewStack()
+	for el := stack.values.Front(); el != nil; el = el.Next() {
+		c.values.PushBack(el.Value)
+	}
+	c.size, c.largest = stack.size, stack.largest
+	return c
+}
+
+// Peek returns the value n positions down from the top of the stack, where
+// 0 is the top, or nil if there aren't enough values.
+func (stack *Stack) Peek(n int) []byte {
+	return stack.bytes(stack.PeekValue(n))
+}
+
+// PeekValue is like Peek, but doesn't load the value into memory.
+func (stack *Stack) PeekValue(n int) Value {
+	el := stack.element(n)
+	if el == nil {
+		return nil
+	}
+	return value(el)
+}
+
+// Roll moves the value n positions down from the top of the stack to the
+// top, returning false if there aren't enough values.
+func (stack *Stack) Roll(n int) bool {
+	el := stack.element(n)
+	if el == nil {
+		return false
+	}
+	stack.values.MoveToFront(el)
+	return true
+}
+
+func (stack *Stack) Clear() {
+	stack.values.Init()
+	stack.size = 0
+	stack.err = nil
+}
+
+func (stack *Stack) element(n int) *list.Element {
+	if n < 0 {
+		return nil
+	}
+	el := stack.values.Front()
+	for ; el != nil && n > 0; n-- {
+		el = el.Next()
+	}
+	return el
+}
+
+// remove takes the value n positions down from the top off the stack
+func (stack *Stack) remove(n int) {
+	if el := stack.element(n); el != nil {
+		stack.values.Remove(el)
+		stack.size -= memSize(value(el))
+	}
+}
+
+// replace changes the value held by an element of the stack
+func (stack *Stack) replace(el *list.Element, v Value) {
+	stack.size -= memSize(value(el))
+	el.Value = v
+	stack.added(v)
+}
+
+// added keeps track of the size of a value put on the stack
+func (stack *Stack) added(v Value) {
+	n := memSize(v)
+	stack.size += n
+	stack.largest = max(stack.largest, n)
+}
+
+// bytes returns the contents of a value, or nil for no value. Commands load
+// their arguments using loadValues, so this only reads a file for callers of
+// the exported methods. If the file cannot be read, it returns nil and keeps
+// the error for takeErr.
+func (stack *Stack) bytes(v Value) []byte {
+	if v == nil {
+		return nil
+	}
+	b, err := valueBytes(v)
+	if err != nil {
+		if stack.err == nil {
+			stack.err = err
+		}
+		return nil
+	}
+	return b
+}
+
+// takeErr returns the first error reading a value since the last call
+func (stack *Stack) takeErr() error {
+	err := stack.err
+	stack.err = nil
+	return err
+}
+
+// takeLargest returns the size of the largest value held in memory that was
+// put on the stack since the last call
+func (stack *Stack) takeLargest() int {
+	n := stack.largest
+	stack.largest = 0
+	return n
+}
+
+func value(el *list.Element) Value {
+	val, ok := el.Value.(Value)
+	if !ok {
+		panic("Why is it not a Value?")
+	}
+	return val
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// RunStats profiles a run. The time and bytes of a call include the
+// operations it runs.
+type RunStats struct {
+	Ops      []OpStats     `json:"ops"`     // each operation in the order it started
+	Summary  []OpSummary   `json:"summary"` // totals for each operation, slowest first
+	Duration time.Duration `json:"duration"`
+}
+
+// OpStats measures one operation. BytesIn is the size of the values it took
+// from the stack, and BytesOut is the size of the values it left there.
+type OpStats struct {
+	Op       string        `json:"op"`
+	Depth    int           `json:"depth"`
+	Duration time.Duration `json:"duration"`
+	BytesIn  int64         `json:"bytesIn"`
+	BytesOut int64         `json:"bytesOut"`
+}
+
+// OpSummary adds up the runs of an operation.
+type OpSummary struct {
+	Op       string        `json:"op"`
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration"`
+	BytesIn  int64         `json:"bytesIn"`
+	BytesOut int64         `json:"bytesOut"`
+}
+
+// RunContextStats is like RunContext, but also returns a profile of the run,
+// which covers the operations that ran even when the run fails.
+func (e *Engine) RunContextStats(ctx context.Context, commands []string) ([]byte, *RunStats, error) {
+	var b []byte
+	var err error
+	stats := e.profile(func() { b, err = e.RunContext(ctx, commands) })
+	return b, stats, err
+}
+
+// RunProgramStats is like RunProgramContext, but also returns a profile of
+// the run, which covers the operations that ran even when the run fails.
+func (e *Engine) RunProgramStats(ctx context.Context, p *Program) ([]byte, *RunStats, error) {
+	var b []byte
+	var err error
+	stats := e.profile(func() { b, err = e.RunProgramContext(ctx, p) })
+	return b, stats, err
+}
+
+// RunValueStats is like RunValue, but also returns a profile of the run,
+// which covers the operations that ran even when the run fails.
+func (e *Engine) RunValueStats(ctx context.Context, p *Program) (Value, *RunStats, error) {
+	var v Value
+	var err error
+	stats := e.profile(func() { v, err = e.RunValue(ctx, p) })
+	return v, stats, err
+}
+
+// profile calls run with profiling turned on, returning the profile
+func (e *Engine) profile(run func()) *RunStats {
+	stats := new(RunStats)
+	e.stats = stats
+	defer func() { e.stats = nil }()
+	run()
+	return stats
+}
+
+// finish records the length of the run and adds up the operations
+func (s *RunStats) finish(d time.Duration) {
+	s.Duration = d
+	m := make(map[string]int)
+	s.Summary = s.Summary[:0]
+	for _, o := range s.Ops {
+		i, ok := m[o.Op]
+		if !ok {
+			i = len(s.Summary)
+			m[o.Op] = i
+			s.Summary = append(s.Summary, OpSummary{Op: o.Op})
+		}
+		t := &s.Summary[i]
+		t.Count++
+		t.Duration += o.Duration
+		t.BytesIn += o.BytesIn
+		t.BytesOut += o.BytesOut
+	}
+	sort.SliceStable(s.Summary, func(i, j int) bool { return s.Summary[i].Duration > s.Summary[j].Duration })
+}
+
+// stackDelta returns the bytes an operation took from the stack and the bytes
+// it left there, given the stack before and after it ran. Values below the
+// ones it changed are still the same slices.
+func stackDelta(before, after []Value) (in, out int64) {
+	n := 0
+	for n < len(before) && n < len(after) && sameValue(before[n], after[n]) {
+		n++
+	}
+	for _, v := range before[n:] {
+		in += int64(v.Len())
+	}
+	for _, v := range after[n:] {
+		out += int64(v.Len())
+	}
+	return in, out
+}
+
+func sameSlice(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
@@ -0,0 +1,527 @@
+package engine
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"hash/crc64"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/twofish"
+)
+
+/*
+	Streaming runs a program on data that is read from an io.Reader instead
+	of being held on the stack. The data starts at the bottom of the stack,
+	and the commands that use it are chained together as readers. Commands
+	that only use values pushed above it run as usual. The stream ends when
+	it is hashed, leaving the hash on the stack, or at the end of the
+	program, when it is copied to the output.
+*/
+
+// ErrNotStreamable is returned by RunStream for a program that must be run
+// with RunProgram.
+var ErrNotStreamable = errors.New("the program cannot be streamed")
+
+// hashFuncs holds the hashes that can be computed over a stream
+var hashFuncs = map[string]func() hash.Hash{
+	"md5":       md5.New,
+	"sha1":      sha1.New,
+	"sha224":    sha256.New224,
+	"sha256":    sha256.New,
+	"sha384":    sha512.New384,
+	"sha512":    sha512.New,
+	"ripemd160": ripemd160.New,
+
+	"sha3-224":    newSHA3_224,
+	"sha3-256":    newSHA3_256,
+	"sha3-384":    newSHA3_384,
+	"sha3-512":    newSHA3_512,
+	"sha512-224":  sha512.New512_224,
+	"sha512-256":  sha512.New512_256,
+	"blake2b-256": newBlake2b256,
+	"blake2b-384": newBlake2b384,
+	"blake2b-512": newBlake2b512,
+	"blake2s-256": newBlake2s256,
+
+	"adler32":          func() hash.Hash { return adler32.New() },
+	"crc32":            func() hash.Hash { return crc32.NewIEEE() },
+	"crc32-ieee":       func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.IEEE)) },
+	"crc32-castagnoli": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"crc32-koopman":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Koopman)) },
+	"crc64-iso":        func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ISO)) },
+	"crc64-ecma":       func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) },
+	"fnv32":            func() hash.Hash { return fnv.New32() },
+	"fnv32a":           func() hash.Hash { return fnv.New32a() },
+	"fnv64":            func() hash.Hash { return fnv.New64() },
+	"fnv64a":           func() hash.Hash { return fnv.New64a() },
+}
+
+// hmacFuncs holds the HMAC hashes that can be computed over a stream. The
+// key is on the stack above the data.
+var hmacFuncs = map[string]func() hash.Hash{
+	"hmac-md5":       md5.New,
+	"hmac-sha1":      sha1.New,
+	"hmac-sha224":    sha256.New224,
+	"hmac-sha256":    sha256.New,
+	"hmac-sha384":    sha512.New384,
+	"hmac-sha512":    sha512.New,
+	"hmac-ripemd160": ripemd160.New,
+
+	"hmac-sha3-224":    newSHA3_224,
+	"hmac-sha3-256":    newSHA3_256,
+	"hmac-sha3-384":    newSHA3_384,
+	"hmac-sha3-512":    newSHA3_512,
+	"hmac-sha512-224":  sha512.New512_224,
+	"hmac-sha512-256":  sha512.New512_256,
+	"hmac-blake2b-256": newBlake2b256,
+	"hmac-blake2b-384": newBlake2b384,
+	"hmac-blake2b-512": newBlake2b512,
+	"hmac-blake2s-256": newBlake2s256,
+}
+
+// keyedFuncs holds the other hashes that can be computed over a stream.
+// They pop a key or an output length from the stack above the data.
+var keyedFuncs = map[string]func(e *Engine) (hash.Hash, error){
+	"mac-blake2b-256": func(e *Engine) (hash.Hash, error) { return e.newBlake2MAC("mac-blake2b-256", blake2b.New256) },
+	"mac-blake2b-384": func(e *Engine) (hash.Hash, error) { return e.newBlake2MAC("mac-blake2b-384", blake2b.New384) },
+	"mac-blake2b-512": func(e *Engine) (hash.Hash, error) { return e.newBlake2MAC("mac-blake2b-512", blake2b.New512) },
+	"mac-blake2s-256": func(e *Engine) (hash.Hash, error) { return e.newBlake2MAC("mac-blake2s-256", blake2s.New256) },
+	"shake128":        func(e *Engine) (hash.Hash, error) { return e.newShake("shake128", sha3.NewSHAKE128) },
+	"shake256":        func(e *Engine) (hash.Hash, error) { return e.newShake("shake256", sha3.NewSHAKE256) },
+}
+
+// isStreamHash reports whether the named command hashes the stream
+func isStreamHash(name string) bool {
+	return hashFuncs[name] != nil || hmacFuncs[name] != nil || keyedFuncs[name] != nil
+}
+
+// newStreamHash makes the hash for the named command, popping its key or
+// length from the stack
+func (e *Engine) newStreamHash(name string) (hash.Hash, error) {
+	if f, ok := hashFuncs[name]; ok {
+		return f(), nil
+	}
+	if f, ok := hmacFuncs[name]; ok {
+		key := e.stack.Pop()
+		if key == nil {
+			return nil, errors.New("no key provided for hmac")
+		}
+		return hmac.New(f, key), nil
+	}
+	return keyedFuncs[name](e)
+}
+
+// A streamStage pops the arguments of a command from the stack and returns
+// a reader for the result of the command applied to r.
+type streamStage func(e *Engine, r io.Reader) (io.Reader, error)
+
+// streamStages holds the commands that can transform a stream
+var streamStages = map[string]streamStage{
+	"hex":          encoder(func(w io.Writer) io.WriteCloser { return nopCloser{hex.NewEncoder(w)} }),
+	"unhex":        decoder("hex", hex.NewDecoder),
+	"base32":       encoder(func(w io.Writer) io.WriteCloser { return base32.NewEncoder(base32.StdEncoding, w) }),
+	"unbase32":     decoder("base32", func(r io.Reader) io.Reader { return base32.NewDecoder(base32.StdEncoding, r) }),
+	"base32-hex":   encoder(func(w io.Writer) io.WriteCloser { return base32.NewEncoder(base32.HexEncoding, w) }),
+	"unbase32-hex": decoder("base32-hex", func(r io.Reader) io.Reader { return base32.NewDecoder(base32.HexEncoding, r) }),
+	"base64":       encoder(func(w io.Writer) io.WriteCloser { return base64.NewEncoder(base64.StdEncoding, w) }),
+	"unbase64":     decoder("base64", func(r io.Reader) io.Reader { return base64.NewDecoder(base64.StdEncoding, r) }),
+	"base64-url":   encoder(func(w io.Writer) io.WriteCloser { return base64.NewEncoder(base64.URLEncoding, w) }),
+	"unbase64-url": decoder("base64-url", func(r io.Reader) io.Reader { return base64.NewDecoder(base64.URLEncoding, r) }),
+
+	"zlib": encoder(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }),
+	"unzlib": func(e *Engine, r io.Reader) (io.Reader, error) {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, decodeError("zlib", err)
+		}
+		return decodeReader{format: "zlib", r: zr}, nil
+	},
+	"deflate": leveled(func(w io.Writer, level int) (io.WriteCloser, error) { return flate.NewWriter(w, level) }),
+	"inflate": decoder("deflate", flate.NewReader),
+	"gzip":    leveled(func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) }),
+	"ungzip": func(e *Engine, r io.Reader) (io.Reader, error) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, decodeError("gzip", err)
+		}
+		return decodeReader{format: "gzip", r: zr}, nil
+	},
+}
+
+func init() {
+	// the ciphers use the stream modes of the block ciphers
+	blocks := map[string]func(e *Engine) func(key []byte) (cipher.Block, error){
+		"aes":      func(*Engine) func([]byte) (cipher.Block, error) { return aes.NewCipher },
+		"des":      func(*Engine) func([]byte) (cipher.Block, error) { return des.NewCipher },
+		"3des":     func(*Engine) func([]byte) (cipher.Block, error) { return des.NewTripleDESCipher },
+		"blowfish": func(*Engine) func([]byte) (cipher.Block, error) { return newBlowfish },
+		"twofish":  func(*Engine) func([]byte) (cipher.Block, error) { return newTwofish },
+		"blowfish-salt": func(e *Engine) func([]byte) (cipher.Block, error) {
+			salt := e.stack.Pop()
+			return func(key []byte) (cipher.Block, error) { return blowfish.NewSaltedCipher(key, salt) }
+		},
+	}
+	modes := map[string]func(block cipher.Block, iv []byte) cipher.Stream{
+		"%s-cfb":   cipher.NewCFBEncrypter,
+		"un%s-cfb": cipher.NewCFBDecrypter,
+		"%s-ofb":   cipher.NewOFB,
+		"%s-ctr":   cipher.NewCTR,
+	}
+	for alg, newBlockFunc := range blocks {
+		for format, mode := range modes {
+			streamStages[fmt.Sprintf(format, alg)] = func(e *Engine, r io.Reader) (io.Reader, error) {
+				cipherBlock := newBlockFunc(e)
+				key := e.stack.Pop()
+				iv := e.stack.Pop()
+				block, err := newBlock(cipherBlock, key, iv)
+				if err != nil {
+					return nil, err
+				}
+				return cipher.StreamReader{S: mode(block, iv), R: r}, nil
+			}
+		}
+	}
+}
+
+func newBlowfish(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+func newTwofish(key []byte) (cipher.Block, error) {
+	return twofish.NewCipher(key)
+}
+
+// encoder returns a stage for an encoder that writes its output
+func encoder(newWriter func(w io.Writer) io.WriteCloser) streamStage {
+	return func(e *Engine, r io.Reader) (io.Reader, error) {
+		return e.pipe(r, func(w io.Writer) (io.WriteCloser, error) { return newWriter(w), nil })
+	}
+}
+
+// leveled returns a stage for a compressor that pops its level from the stack
+func leveled(newWriter func(w io.Writer, level int) (io.WriteCloser, error)) streamStage {
+	return func(e *Engine, r io.Reader) (io.Reader, error) {
+		level, err := e.stack.PopInt()
+		if err != nil {
+			return nil, err
+		}
+		return e.pipe(r, func(w io.Writer) (io.WriteCloser, error) { return newWriter(w, level) })
+	}
+}
+
+// decoder returns a stage for a decoder that reads its input
+func decoder[R io.Reader](format string, newReader func(r io.Reader) R) streamStage {
+	return func(e *Engine, r io.Reader) (io.Reader, error) {
+		return decodeReader{format: format, r: newReader(r)}, nil
+	}
+}
+
+// pipe returns a reader for the output of a writer, which copies r to it in
+// a goroutine. The goroutine ends when r is exhausted or the run ends.
+func (e *Engine) pipe(r io.Reader, newWriter func(w io.Writer) (io.WriteCloser, error)) (io.Reader, error) {
+	pr, pw := io.Pipe()
+	w, err := newWriter(pw)
+	if err != nil {
+		return nil, err
+	}
+	e.pipes = append(e.pipes, pr)
+	go func() {
+		_, err := io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// closePipes stops any pipes still copying data
+func (e *Engine) closePipes() {
+	for _, pr := range e.pipes {
+		pr.Close()
+	}
+	clear(e.pipes)
+	e.pipes = e.pipes[:0]
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// decodeReader reports the errors of a decoder as DecodeErrors
+type decodeReader struct {
+	format string
+	r      io.Reader
+}
+
+func (d decodeReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = decodeError(d.format, err)
+	}
+	return n, err
+}
+
+// stageReader is the output of a command that transforms the stream. Its
+// errors name the command.
+type stageReader struct {
+	op *OpError
+	r  io.Reader
+}
+
+func (s stageReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		var se *streamError
+		if !errors.As(err, &se) {
+			op := *s.op
+			op.Err = err
+			se = &streamError{OpError: &op}
+		}
+		err = se
+	}
+	return n, err
+}
+
+// streamError is an error from reading the stream, which names the command
+// whose stage failed rather than the command reading it
+type streamError struct {
+	*OpError
+}
+
+func (s *streamError) Unwrap() error {
+	return s.OpError
+}
+
+// asStreamError returns a streamError unchanged, so that it is not wrapped by
+// the command that was reading
+func asStreamError(err error) error {
+	var se *streamError
+	if errors.As(err, &se) {
+		return se
+	}
+	return err
+}
+
+// limitedReader stops reading if the run is cancelled or runs out of time.
+// Unlike checkedReader, it does not refer to the engine, so it can be read
+// from the goroutines of a pipe.
+type limitedReader struct {
+	ctx      context.Context
+	deadline time.Time
+	timeout  time.Duration
+	r        io.Reader
+}
+
+func (l limitedReader) Read(p []byte) (int, error) {
+	if err := l.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return 0, &LimitExceededError{Err: ErrTimeout, Limit: int64(l.timeout)}
+	}
+	return l.r.Read(p)
+}
+
+// streamState tracks a simulated run of a program to see if it can be streamed
+type streamState struct {
+	above     int  // values on the stack above the stream
+	streaming bool // whether the stream is still on the stack
+	used      bool // whether a command used the stream
+}
+
+// Streamable reports whether the engine can run the program using
+// RunStream. The commands that use the value at the bottom of the stack must
+// all support streaming, and the program must end with the stream or with
+// its hash as the only value on the stack. Programs using functions added
+// with Register cannot be streamed.
+func (e *Engine) Streamable(p *Program) bool {
+	if !p.known || p.needs > 1 || p.effect != 0 {
+		return false
+	}
+	for _, w := range p.words {
+		if _, ok := e.funcs[w]; ok {
+			return false
+		}
+	}
+	s := streamState{streaming: true}
+	return s.check(p) && s.used && (!s.streaming || s.above == 0)
+}
+
+func (s *streamState) check(p *Program) bool {
+	for i := range p.ops {
+		o := &p.ops[i]
+		in, out, _ := o.effect()
+		if s.streaming {
+			switch {
+			case o.macro != nil && o.macro.needs > s.above:
+				if !s.check(o.macro) {
+					return false
+				}
+				continue
+			case in == s.above+1 && o.fd != nil:
+				name := strings.TrimSpace(o.name)
+				if _, ok := streamStages[name]; ok {
+					s.above, s.used = 0, true
+					continue
+				}
+				if isStreamHash(name) {
+					s.above, s.streaming, s.used = 1, false, true
+					continue
+				}
+				return false
+			case in > s.above:
+				return false
+			}
+		}
+		s.above += out - in
+	}
+	return true
+}
+
+// RunStream runs a program on data read from r, writing the result to w.
+// The data takes the place of the value at the bottom of the stack, and is
+// processed in pieces, so it can be much larger than memory. Use Streamable
+// to find out whether a program can be streamed. The limits on the size of
+// values do not apply to the streamed data, since it is not kept in memory.
+// Debug mode and tracing are not supported.
+func (e *Engine) RunStream(ctx context.Context, p *Program, r io.Reader, w io.Writer) error {
+	if !e.Streamable(p) {
+		return ErrNotStreamable
+	}
+	e.ctx = ctx
+	defer func() { e.ctx = context.Background() }()
+	e.startLimits()
+
+	e.piped = true
+	defer func() { e.piped = false }()
+	defer e.closePipes()
+
+	src := io.Reader(limitedReader{ctx: ctx, deadline: e.deadline, timeout: e.Limits.Timeout, r: r})
+	src, err := e.streamProgram(p, src)
+	if err == nil {
+		if src != nil {
+			_, err = io.Copy(w, src)
+		} else {
+			var b []byte
+			if b, err = e.stack.popBytes(); err == nil {
+				_, err = w.Write(b)
+			}
+		}
+	}
+	if se, ok := err.(*streamError); ok {
+		return se.OpError
+	}
+	return err
+}
+
+// streamProgram runs the commands of a program, passing the stream through
+// the commands that use it. It returns the stream, or nil once it is hashed.
+func (e *Engine) streamProgram(p *Program, src io.Reader) (io.Reader, error) {
+	e.depth++
+	defer func() { e.depth-- }()
+	if e.Limits.MaxCallDepth > 0 && e.depth > e.Limits.MaxCallDepth {
+		return src, &LimitExceededError{Err: ErrCallDepth, Limit: int64(e.Limits.MaxCallDepth)}
+	}
+
+	e.Log("stream ", p)
+	for i := range p.ops {
+		o := &p.ops[i]
+		n := e.stack.Len()
+		if src != nil {
+			n++
+		}
+		err := e.checkOp()
+		if err == nil {
+			src, err = e.streamOp(o, src)
+			if r, ok := src.(stageReader); ok && r.op == nil {
+				// name the command in errors from the new stage
+				src = stageReader{op: &OpError{Op: o.name, Index: i, StackDepth: n}, r: r.r}
+			}
+		}
+		if err == nil {
+			err = e.checkStack()
+		}
+		if se, ok := err.(*streamError); ok {
+			// the error came from reading the stream, and names the command that failed
+			return src, se
+		}
+		if err != nil {
+			return src, &OpError{Op: o.name, Index: i, StackDepth: n, Err: err}
+		}
+	}
+	e.Log("end")
+	return src, nil
+}
+
+// streamOp runs a single command, using the stream if the command needs it
+func (e *Engine) streamOp(o *op, src io.Reader) (io.Reader, error) {
+	if src == nil {
+		return nil, e.execOp(o)
+	}
+	if o.macro != nil && o.macro.needs > e.stack.Len() {
+		if !e.valueIs(o.name, o.src) {
+			return src, fmt.Errorf("%s was replaced and cannot be streamed", o.name)
+		}
+		e.Logf("call %s", o.name)
+		return e.streamProgram(o.macro, src)
+	}
+	if o.fd == nil || o.in != e.stack.Len()+1 {
+		return src, e.execOp(o)
+	}
+
+	name := strings.TrimSpace(o.name)
+	if stage, ok := streamStages[name]; ok {
+		e.Logf("stream -> %s", o.name)
+		r, err := stage(e, src)
+		if err != nil {
+			return src, err
+		}
+		return stageReader{r: r}, nil
+	}
+	h, err := e.newStreamHash(name)
+	if err != nil {
+		return src, err
+	}
+	e.Logf("stream -> %s -> (%s)", o.name, o.fd.Out)
+	if _, err := io.Copy(h, src); err != nil {
+		return src, asStreamError(err)
+	}
+	b, err := sum(h)
+	if err != nil {
+		return src, err
+	}
+	e.stack.Push(b)
+	e.piped = false
+	return nil, nil
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const (
+	maxPatternLen = 1024 // longest regular expression accepted
+	maxPatterns   = 256  // number of compiled regular expressions to cache
+)
+
+var (
+	patternMu    sync.Mutex
+	patternCache = make(map[string]*regexp.Regexp)
+)
+
+// compilePattern compiles a regular expression, reusing earlier results
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLen {
+		return nil, fmt.Errorf("pattern is longer than %d bytes", maxPatternLen)
+	}
+	patternMu.Lock()
+	defer patternMu.Unlock()
+	if re, ok := patternCache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patternCache) >= maxPatterns {
+		clear(patternCache)
+	}
+	patternCache[pattern] = re
+	return re, nil
+}
+
+// transform pops a value and pushes the result of f
+func (e *Engine) transform(op string, f func([]byte) []byte) error {
+	b := e.stack.Pop()
+	if b == nil {
+		return fmt.Errorf("%s: expected 1 value on the stack", op)
+	}
+	e.stack.Push(f(b))
+	return nil
+}
+
+func (e *Engine) lower() error {
+	return e.transform("lower", bytes.ToLower)
+}
+
+func (e *Engine) upper() error {
+	return e.transform("upper", bytes.ToUpper)
+}
+
+func (e *Engine) trim() error {
+	return e.transform("trim", bytes.TrimSpace)
+}
+
+func (e *Engine) replace() error {
+	newVal := e.stack.Pop()
+	oldVal := e.stack.Pop()
+	b := e.stack.Pop()
+	if newVal == nil || oldVal == nil || b == nil {
+		return errors.New("replace: expected 3 values on the stack")
+	}
+	if len(oldVal) == 0 {
+		return invalidArg("replace", "value to replace is empty")
+	}
+	e.stack.Push(bytes.ReplaceAll(b, oldVal, newVal))
+	return nil
+}
+
+func (e *Engine) regex_match() error {
+	pattern := e.stack.Pop()
+	b := e.stack.Pop()
+	if pattern == nil || b == nil {
+		return errors.New("regex-match: expected 2 values on the stack")
+	}
+	re, err := compilePattern(string(pattern))
+	if err != nil {
+		return &InvalidArgumentError{Op: "regex-match", Err: err}
+	}
+	if re.Match(b) {
+		e.stack.Push([]byte("1"))
+	} else {
+		e.stack.Push([]byte("0"))
+	}
+	return nil
+}
+
+func (e *Engine) regex_replace() error {
+	repl := e.stack.Pop()
+	pattern := e.stack.Pop()
+	b := e.stack.Pop()
+	if repl == nil || pattern == nil || b == nil {
+		return errors.New("regex-replace: expected 3 values on the stack")
+	}
+	re, err := compilePattern(string(pattern))
+	if err != nil {
+		return &InvalidArgumentError{Op: "regex-replace", Err: err}
+	}
+	e.stack.Push(re.ReplaceAll(b, repl))
+	return nil
+}
+
+func (e *Engine) split() error {
+	sep := e.stack.Pop()
+	b := e.stack.Pop()
+	if sep == nil || b == nil {
+		return errors.New("split: expected 2 values on the stack")
+	}
+	if len(sep) == 0 {
+		return invalidArg("split", "separator is empty")
+	}
+	parts := bytes.Split(b, sep)
+	if e.Limits.MaxStackItems > 0 && e.stack.Len()+len(parts)+1 > e.Limits.MaxStackItems {
+		return &LimitExceededError{Err: ErrStackItems, Limit: int64(e.Limits.MaxStackItems)}
+	}
+	for _, p := range parts {
+		e.stack.Push(p)
+	}
+	e.stack.Push([]byte(strconv.Itoa(len(parts))))
+	return nil
+}
+
+func (e *Engine) join() error {
+	sep := e.stack.Pop()
+	count, err := e.stack.PopInt()
+	if err != nil {
+		return err
+	}
+	if sep == nil {
+		return errors.New("join: expected a separator on the stack")
+	}
+	if count < 0 {
+		return invalidArg("join", "count cannot be negative")
+	}
+	if count > e.stack.Len() {
+		return &StackUnderflowError{Op: "join", Need: count, Have: e.stack.Len()}
+	}
+	parts := make([][]byte, count)
+	for i := count - 1; i >= 0; i-- {
+		parts[i] = e.stack.Pop()
+	}
+	e.stack.Push(bytes.Join(parts, sep))
+	return nil
+}
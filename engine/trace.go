@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// A Tracer receives each step of a run. Set Engine.Tracer to watch a run, or
+// use debug mode to get an HTML page describing the run instead of the result.
+type Tracer interface {
+	// Step is called after each operation.
+	Step(s *Step)
+	// Message is called with the text written by Log and Logf.
+	Message(text string)
+}
+
+// Step describes one operation of a run. Secrets are already redacted.
+type Step struct {
+	Op       string        `json:"op"`
+	Kind     string        `json:"kind"` // func, call, or push
+	Depth    int           `json:"depth"`
+	Before   []TraceValue  `json:"before"`
+	After    []TraceValue  `json:"after"`
+	Vars     []TraceValue  `json:"vars,omitempty"` // variables that changed
+	Duration time.Duration `json:"duration"`
+	BytesIn  int64         `json:"bytesIn"`  // size of the values taken from the stack
+	BytesOut int64         `json:"bytesOut"` // size of the values left on the stack
+	Err      error         `json:"-"`
+}
+
+// TraceValue is a value prepared for display. For a secret, Text and Hex only
+// show its length and fingerprint.
+type TraceValue struct {
+	Name   string `json:"name,omitempty"`
+	Length int    `json:"length"`
+	Text   string `json:"text"`
+	Hex    string `json:"hex"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// reporter is a Tracer that builds the output of a run in debug mode
+type reporter interface {
+	report(result *TraceValue, err error, stats *RunStats) []byte
+}
+
+// tableTracer is a Tracer that shows the tables written by LogStack and LogValues
+type tableTracer interface {
+	table(name string, rows []TraceValue)
+}
+
+// startTrace selects the tracer for a run. In debug mode, it returns the
+// reporter that builds the output, or nil if the tracer cannot.
+func (e *Engine) startTrace() reporter {
+	e.trace = e.Tracer
+	if e.trace == nil && e.DebugMode {
+		e.logBuf.Reset()
+		e.trace = &htmlTracer{buf: e.logBuf}
+	}
+	if r, ok := e.trace.(reporter); ok && e.DebugMode {
+		return r
+	}
+	return nil
+}
+
+// beginStep captures the state before running an operation
+func (e *Engine) beginStep(o *op) *Step {
+	s := &Step{Op: o.name, Depth: e.depth, Before: e.stackValues()}
+	switch {
+	case o.fd != nil:
+		s.Kind = "func"
+	case o.macro != nil:
+		s.Kind = "call"
+	default:
+		s.Kind = "push"
+		if _, ok := e.funcs[strings.TrimSpace(o.name)]; ok && o.word {
+			s.Kind = "func"
+		}
+	}
+	return s
+}
+
+// endStep finishes a step and passes it to the tracer
+func (e *Engine) endStep(s *Step, vars map[string]Value, err error) {
+	s.After = e.stackValues()
+	for k, v := range e.values {
+		if old, ok := vars[k]; !ok || !equalValues(old, v) {
+			s.Vars = append(s.Vars, e.variableValue(k, v))
+		}
+	}
+	s.Err = err
+	e.trace.Step(s)
+}
+
+// htmlTracer writes the debug page
+type htmlTracer struct {
+	buf     *bytes.Buffer
+	started bool
+}
+
+func (h *htmlTracer) start() {
+	if !h.started {
+		h.buf.WriteString(htmlHeader)
+		h.started = true
+	}
+}
+
+func (h *htmlTracer) Step(s *Step) {
+	h.start()
+	tpl.ExecuteTemplate(h.buf, "Timing", s)
+	h.table("Stack", s.After)
+}
+
+func (h *htmlTracer) Message(text string) {
+	h.start()
+	// ignoring errors
+	tpl.ExecuteTemplate(h.buf, "Text", text)
+}
+
+func (h *htmlTracer) table(name string, rows []TraceValue) {
+	h.start()
+	tpl.ExecuteTemplate(h.buf, name, rows)
+}
+
+func (h *htmlTracer) report(result *TraceValue, err error, stats *RunStats) []byte {
+	h.start()
+	if stats != nil {
+		tpl.ExecuteTemplate(h.buf, "Summary", stats)
+	}
+	h.buf.WriteString(htmlFooter)
+	return h.buf.Bytes()
+}
+
+// JSONTracer records the steps and messages of a run. In debug mode, the run
+// returns them as a JSON document instead of the result.
+type JSONTracer struct {
+	Entries []TraceEntry `json:"trace"`
+}
+
+// TraceEntry is either a step or a message recorded by a JSONTracer.
+type TraceEntry struct {
+	*Step
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (j *JSONTracer) Step(s *Step) {
+	t := TraceEntry{Step: s}
+	if s.Err != nil {
+		t.Error = s.Err.Error()
+	}
+	j.Entries = append(j.Entries, t)
+}
+
+func (j *JSONTracer) Message(text string) {
+	j.Entries = append(j.Entries, TraceEntry{Message: text})
+}
+
+func (j *JSONTracer) report(result *TraceValue, err error, stats *RunStats) []byte {
+	out := struct {
+		*JSONTracer
+		Result *TraceValue `json:"result,omitempty"`
+		Error  string      `json:"error,omitempty"`
+		Stats  *RunStats   `json:"stats,omitempty"`
+	}{JSONTracer: j, Result: result, Stats: stats}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return b
+}
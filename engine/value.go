@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+/*
+	Values larger than the engine's SpillBytes are kept in temporary files
+	instead of memory. The commands that only move values around, hash them,
+	or transform them in a way that can be streamed read the files directly.
+	Other commands load their arguments into memory first, subject to the
+	limits on the size of values. The files are removed by Reset.
+*/
+
+// A Value is a value on the stack or in a variable. It may be held in memory
+// or kept in a temporary file, so read it using Reader. A Value belongs to
+// the engine that made it, and a file is only valid until the engine is
+// Reset.
+type Value interface {
+	Len() int          // the size of the value in bytes
+	Reader() io.Reader // reads the value from the start
+}
+
+// bytesValue is a value held in memory
+type bytesValue []byte
+
+func (b bytesValue) Len() int {
+	return len(b)
+}
+
+func (b bytesValue) Reader() io.Reader {
+	return bytes.NewReader(b)
+}
+
+// fileValue is a value kept in a temporary file. Each reader has its own
+// position, so the file can be shared by several values on the stack.
+type fileValue struct {
+	f *os.File
+	n int
+}
+
+func (f *fileValue) Len() int {
+	return f.n
+}
+
+func (f *fileValue) Reader() io.Reader {
+	return io.NewSectionReader(f.f, 0, int64(f.n))
+}
+
+// valueBytes returns the contents of a value, reading it from its file if
+// it is not in memory
+func valueBytes(v Value) ([]byte, error) {
+	if b, ok := v.(bytesValue); ok {
+		return b, nil
+	}
+	b := make([]byte, v.Len())
+	_, err := io.ReadFull(v.Reader(), b)
+	return b, err
+}
+
+// memSize returns the size of a value if it is held in memory, or zero if
+// it is kept in a temporary file
+func memSize(v Value) int {
+	if b, ok := v.(bytesValue); ok {
+		return len(b)
+	}
+	return 0
+}
+
+// sameValue reports whether two values are the same value, rather than
+// equal values
+func sameValue(a, b Value) bool {
+	if x, ok := a.(bytesValue); ok {
+		y, ok := b.(bytesValue)
+		return ok && sameSlice(x, y)
+	}
+	return a == b
+}
+
+// equalValues reports whether two values have the same contents, without
+// reading the files of values that are not in memory
+func equalValues(a, b Value) bool {
+	x, ok1 := a.(bytesValue)
+	y, ok2 := b.(bytesValue)
+	if ok1 && ok2 {
+		return bytes.Equal(x, y)
+	}
+	return a == b
+}
+
+// ReadValue reads a value from r, such as a request body, keeping it in a
+// temporary file if it is larger than SpillBytes. Use PushValue to put it
+// on the stack or SetReadOnlyValue to make it a variable.
+func (e *Engine) ReadValue(r io.Reader) (Value, error) {
+	if e.SpillBytes <= 0 {
+		b, err := e.readAll(r)
+		return bytesValue(b), err
+	}
+	r = checkedReader{e: e, r: r}
+	b, err := io.ReadAll(io.LimitReader(r, int64(e.SpillBytes)+1))
+	if err != nil || len(b) <= e.SpillBytes {
+		return bytesValue(b), err
+	}
+	return e.spill(io.MultiReader(bytes.NewReader(b), r))
+}
+
+// PushValue pushes a value made by ReadValue onto the stack.
+func (e *Engine) PushValue(v Value) {
+	e.stack.PushValue(v)
+}
+
+// spill copies r to a new temporary file
+func (e *Engine) spill(r io.Reader) (Value, error) {
+	f, err := os.CreateTemp(e.TempDir, "hashsrv-*")
+	if err != nil {
+		return nil, err
+	}
+	e.temps = append(e.temps, f)
+	limit := int64(math.MaxInt64)
+	if e.Limits.MaxSpillBytes > 0 {
+		limit = int64(e.Limits.MaxSpillBytes-e.spilled) + 1
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit))
+	e.spilled += int(n)
+	if err != nil {
+		return nil, err
+	}
+	if e.Limits.MaxSpillBytes > 0 && e.spilled > e.Limits.MaxSpillBytes {
+		return nil, &LimitExceededError{Err: ErrSpillBytes, Limit: int64(e.Limits.MaxSpillBytes)}
+	}
+	e.Logf("spilled %d bytes to %s", n, f.Name())
+	return &fileValue{f: f, n: int(n)}, nil
+}
+
+// removeTemps closes and removes the temporary files
+func (e *Engine) removeTemps() {
+	for _, f := range e.temps {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			e.logger().Warn("cannot remove temporary file", "file", f.Name(), "error", err)
+		}
+	}
+	clear(e.temps)
+	e.temps = e.temps[:0]
+	e.spilled = 0
+}
+
+// valueFuncs holds the commands that move values around without reading
+// them, so values in temporary files are not loaded into memory for them
+var valueFuncs = map[string]bool{
+	"push": true, "pop": true, "swap": true, "over": true, "rot": true, "-rot": true,
+	"nip": true, "tuck": true, "pick": true, "roll": true, "depth": true, "clear": true,
+	"2dup": true, "2swap": true, "len": true, "save": true,
+}
+
+// loadValues loads the top n values on the stack into memory, or all of
+// them when n is negative, so that a command can use them as bytes
+func (e *Engine) loadValues(n int) error {
+	if n < 0 || n > e.stack.Len() {
+		n = e.stack.Len()
+	}
+	for i := 0; i < n; i++ {
+		el := e.stack.element(i)
+		v, ok := el.Value.(*fileValue)
+		if !ok {
+			continue
+		}
+		if err := e.checkSize(v.Len()); err != nil {
+			return err
+		}
+		e.Logf("loading %d bytes from %s", v.Len(), v.f.Name())
+		b, err := valueBytes(v)
+		if err != nil {
+			return err
+		}
+		e.stack.replace(el, bytesValue(b))
+	}
+	return nil
+}
+
+// execFileOp runs a hash or a streamable command on a value in a temporary
+// file by reading the file in pieces, returning false if the command or the
+// value doesn't allow it
+func (e *Engine) execFileOp(o *op) (bool, error) {
+	name := strings.TrimSpace(o.name)
+	stage, isStage := streamStages[name]
+	if o.in <= 0 || !isStage && !isStreamHash(name) {
+		return false, nil
+	}
+	v, ok := e.stack.PeekValue(o.in - 1).(*fileValue)
+	if !ok {
+		return false, nil
+	}
+	e.stack.remove(o.in - 1)
+	r := io.Reader(limitedReader{ctx: e.ctx, deadline: e.deadline, timeout: e.Limits.Timeout, r: v.Reader()})
+
+	if isStage {
+		e.Logf("file -> %s", o.name)
+		defer e.closePipes()
+		out, err := stage(e, r)
+		if err != nil {
+			return true, err
+		}
+		result, err := e.ReadValue(out)
+		if err != nil {
+			return true, err
+		}
+		e.stack.PushValue(result)
+		return true, nil
+	}
+
+	h, err := e.newStreamHash(name)
+	if err != nil {
+		return true, err
+	}
+	e.Logf("file -> %s -> (%s)", o.name, o.fd.Out)
+	if _, err := io.Copy(h, r); err != nil {
+		return true, err
+	}
+	b, err := sum(h)
+	if err != nil {
+		return true, err
+	}
+	e.stack.Push(b)
+	return true, nil
+}
+
+// valueTrace prepares a value for display, describing a value in a
+// temporary file instead of reading it
+func valueTrace(name string, v Value, secret bool) TraceValue {
+	if b, ok := v.(bytesValue); ok {
+		return traceValue(name, b, secret)
+	}
+	s := fmt.Sprintf("[temporary file: %d bytes]", v.Len())
+	return TraceValue{Name: name, Length: v.Len(), Text: s, Hex: s}
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ErrDefaultKey is returned when loading the default key while the engine's
+// NoDefaultKey option is set.
+var ErrDefaultKey = errors.New("the default key is not allowed; provide a key")
+
+// ReadOnlyError is returned when changing a read-only variable.
+type ReadOnlyError struct {
+	Name string
+}
+
+func (r *ReadOnlyError) Error() string {
+	return "variable " + r.Name + " is read-only"
+}
+
+// SetVariable sets a variable for the engine to use
+func (e *Engine) SetVariable(name string, value []byte) {
+	e.setValue(strings.ToLower(name), bytesValue(value))
+}
+
+// setValue stores a variable, keeping track of the combined size of the
+// variables held in memory
+func (e *Engine) setValue(name string, v Value) {
+	if old, ok := e.values[name]; ok {
+		e.varSize -= memSize(old)
+	}
+	e.values[name] = v
+	e.varSize += memSize(v)
+}
+
+// GetVariable returns the value of a variable in the engine, or nil if it
+// is kept in a temporary file that cannot be read within the size limits
+func (e *Engine) GetVariable(name string) []byte {
+	v, _, _ := e.value(strings.ToLower(name))
+	return v
+}
+
+// value looks up a variable, falling back to the shared defaults when the
+// engine has not set it. A value in a temporary file is read into memory if
+// it is within the size limits.
+func (e *Engine) value(name string) ([]byte, bool, error) {
+	if v, ok := e.values[name]; ok {
+		if _, ok := v.(*fileValue); ok {
+			if err := e.checkSize(v.Len()); err != nil {
+				return nil, true, err
+			}
+		}
+		b, err := valueBytes(v)
+		return b, true, err
+	}
+	v, ok := defaultValues[name]
+	return v, ok, nil
+}
+
+// valueIs reports whether a variable holds the given bytes, only reading a
+// value in a temporary file when it is the same size
+func (e *Engine) valueIs(name string, b []byte) bool {
+	v, ok := e.values[name]
+	if !ok {
+		return bytes.Equal(defaultValues[name], b)
+	}
+	if v.Len() != len(b) {
+		return false
+	}
+	x, err := valueBytes(v)
+	return err == nil && bytes.Equal(x, b)
+}
+
+// variable is like value, but doesn't load a value in a temporary file into
+// memory. It returns nil if there is no value.
+func (e *Engine) variable(name string) Value {
+	if v, ok := e.values[name]; ok {
+		if b, ok := v.(bytesValue); ok && b == nil {
+			return nil
+		}
+		return v
+	}
+	if v := defaultValues[name]; v != nil {
+		return bytesValue(v)
+	}
+	return nil
+}
+
+// variables returns the engine's variables merged with the defaults
+func (e *Engine) variables() map[string]Value {
+	m := make(map[string]Value, len(defaultValues)+len(e.values))
+	for k, v := range defaultValues {
+		m[k] = bytesValue(v)
+	}
+	for k, v := range e.values {
+		m[k] = v
+	}
+	return m
+}
+
+// SetReadOnlyVariable sets a variable that cannot be changed by SetUserVariable
+// or the save command, such as one configured by the server.
+func (e *Engine) SetReadOnlyVariable(name string, value []byte) {
+	e.SetReadOnlyValue(name, bytesValue(value))
+}
+
+// SetReadOnlyValue is like SetReadOnlyVariable, but takes a value made by
+// ReadValue, such as a request body that may be kept in a temporary file.
+func (e *Engine) SetReadOnlyValue(name string, v Value) {
+	name = strings.ToLower(name)
+	e.setValue(name, v)
+	e.readOnly[name] = true
+}
+
+// SetUserVariable sets a variable on behalf of a caller, such as from a request
+// header. It fails with a ReadOnlyError when the variable is read-only.
+func (e *Engine) SetUserVariable(name string, value []byte) error {
+	return e.setUserValue(name, bytesValue(value))
+}
+
+// setUserValue is like SetUserVariable, but takes a value that may be kept
+// in a temporary file
+func (e *Engine) setUserValue(name string, v Value) error {
+	name = strings.ToLower(name)
+	if e.IsReadOnly(name) {
+		return &ReadOnlyError{Name: name}
+	}
+	e.setValue(name, v)
+	return nil
+}
+
+// IsReadOnly reports whether a variable is read-only. The built-in programs
+// are always read-only, but the default key is not, so that callers can
+// provide their own.
+func (e *Engine) IsReadOnly(name string) bool {
+	name = strings.ToLower(name)
+	if e.readOnly[name] {
+		return true
+	}
+	_, builtIn := defaultValues[name]
+	return builtIn && name != "key"
+}
+
+// SetSecretVariable sets a read-only variable that is not shown on the help
+// page and is redacted from the debug output, such as a key configured by the
+// server.
+func (e *Engine) SetSecretVariable(name string, value []byte) {
+	e.SetReadOnlyVariable(name, value)
+	e.MarkSecret(name)
+}
+
+// MarkSecret hides a variable from the help page and redacts it from the debug
+// output, along with any value on the stack that equals it.
+func (e *Engine) MarkSecret(name string) {
+	e.secret[strings.ToLower(name)] = true
+}
+
+// IsSecret reports whether a variable is hidden from the help page and
+// redacted from the debug output. The key is always secret.
+func (e *Engine) IsSecret(name string) bool {
+	name = strings.ToLower(name)
+	return name == "key" || e.secret[name]
+}
+
+// addSecret redacts a value that did not come from a variable, such as
+// unprotected data or a value made from a secret
+func (e *Engine) addSecret(v []byte) {
+	e.secrets = append(e.secrets, v)
+}
+
+// takesSecret reports whether an operation takes a secret value from the
+// stack, given the stack before it runs. A call is not checked, because the
+// operations within it are.
+func (e *Engine) takesSecret(o *op, before []Value) bool {
+	in := o.in
+	if o.fd == nil {
+		fd, ok := e.funcs[strings.TrimSpace(o.name)]
+		if !o.word || !ok {
+			return false
+		}
+		in = countValues(fd.In)
+	}
+	if in < 0 || in > len(before) {
+		in = len(before)
+	}
+	for _, v := range before[len(before)-in:] {
+		if b, ok := v.(bytesValue); ok && e.isSecretValue(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// addSecrets redacts the values an operation left on the stack, given the
+// stack before and after it ran
+func (e *Engine) addSecrets(before, after []Value) {
+	n := 0
+	for n < len(before) && n < len(after) && sameValue(before[n], after[n]) {
+		n++
+	}
+	for _, v := range after[n:] {
+		if b, ok := v.(bytesValue); ok && !e.isSecretValue(b) {
+			e.addSecret(b)
+		}
+	}
+}
+
+// isSecretValue reports whether a value equals a secret variable or one
+// added with addSecret
+func (e *Engine) isSecretValue(v []byte) bool {
+	if len(v) == 0 {
+		return false
+	}
+	if e.valueIs("key", v) {
+		return true
+	}
+	for name := range e.secret {
+		if e.valueIs(name, v) {
+			return true
+		}
+	}
+	for _, s := range e.secrets {
+		if bytes.Equal(v, s) {
+			return true
+		}
+	}
+	return false
+}